@@ -0,0 +1,232 @@
+// Package config sets command-line flags from environment variables and from profiles,
+// YAML or TOML files, so that the flags of a vantage point are kept in one file
+// instead of in the scripts which run the tools.
+//
+// A profile maps flag names, without the dash, to values. A list repeats a flag which can be
+// repeated, eg. blocklist, and a table named after a command holds the flags of that command only.
+// Other tables, and the top-level flags a command does not have, are ignored by that command,
+// which logs the latter, eg. misspelled ones, so that one profile serves several commands:
+//
+//	dip: 1.1.1.1
+//	worker: 200
+//	blocklist: [blocklist.conf, more.conf]
+//	snicensor:
+//	  p: 10000-65000,!22
+//	  timeout: 6s
+//	dnscensor:
+//	  type: A,AAAA
+//
+// A flag takes the first value found in: the command line, the environment variable
+// NAME_FLAG, eg. SNICENSOR_TIMEOUT or SNICENSOR_EXCLUDE_IP, the profile, and its default.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/parseipportargs"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the profile loaded by Apply. RegisterFlags binds it to -config.
+var DefaultPath string
+
+// RegisterFlags defines the -config flag.
+// usage example: in external function, RegisterFlags(flag.CommandLine) before flag.Parse(),
+// then Apply(flag.CommandLine, name) after it.
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&DefaultPath, "config", "", "read the flags not given on the command line from a YAML or TOML profile `file`, after the environment variables NAME_FLAG.")
+}
+
+// validators check the values of the flags shared by the tools, to report errors
+// with the variable or the profile they come from.
+var validators = map[string]func(string) error{
+	"dip": parseipportargs.ValidateTargets,
+	"p": func(s string) error {
+		_, err := parseipportargs.ParsePortSet(s, parseipportargs.TCP)
+		return err
+	},
+	"type": func(s string) error {
+		_, err := parseipportargs.ParseRRTypeArgs(s)
+		return err
+	},
+	"exclude-ip": func(s string) error {
+		_, err := parseipportargs.ParseIPRanges(s)
+		return err
+	},
+}
+
+// Apply sets the flags of fs which were not given on the command line, from the environment variables
+// of the command name, eg. DNSCENSOR_TIMEOUT, then from the profile at DefaultPath, or at NAME_CONFIG.
+// It must be called after fs.Parse.
+func Apply(fs *flag.FlagSet, name string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	prefix := envName(name, "")
+	var fromEnv []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !set[f.Name] && f.Name != "config" {
+			if _, ok := os.LookupEnv(envName(name, f.Name)); ok {
+				fromEnv = append(fromEnv, f.Name)
+			}
+		}
+	})
+	for _, flagName := range fromEnv {
+		v := os.Getenv(envName(name, flagName))
+		if err := setFlag(fs, flagName, v); err != nil {
+			return fmt.Errorf("%v: %w", envName(name, flagName), err)
+		}
+		set[flagName] = true
+	}
+
+	path := DefaultPath
+	if path == "" {
+		path = os.Getenv(prefix + "CONFIG")
+	}
+	if path == "" {
+		return nil
+	}
+	values, own, err := Load(path, name)
+	if err != nil {
+		return err
+	}
+	// in a stable order, for the errors to be reproducible
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ignored []string
+	for _, k := range keys {
+		if fs.Lookup(k) == nil {
+			if own[k] {
+				return fmt.Errorf("%v: unknown flag -%v of %v", path, k, name)
+			}
+			// a flag of other commands, or a typo
+			ignored = append(ignored, "-"+k)
+			continue
+		}
+		if set[k] {
+			continue
+		}
+		for _, v := range values[k] {
+			if err := setFlag(fs, k, v); err != nil {
+				return fmt.Errorf("%v: %v: %w", path, k, err)
+			}
+		}
+	}
+	if len(ignored) > 0 {
+		log.Printf("%v: ignoring %v: not flags of %v", path, strings.Join(ignored, ", "), name)
+	}
+	return nil
+}
+
+// envName returns the environment variable of flag of command name, eg. DNSCENSOR_EXCLUDE_IP.
+func envName(name, flag string) string {
+	s := name + "_" + flag
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s))
+}
+
+func setFlag(fs *flag.FlagSet, name, value string) error {
+	if validate, ok := validators[name]; ok {
+		if err := validate(value); err != nil {
+			return err
+		}
+	}
+	return fs.Set(name, value)
+}
+
+// Load reads the profile at path, a .yaml, .yml or .toml file, and returns the values of every flag
+// for command name: the top-level ones, overridden by those of the table name. own has the keys
+// of the table name, which must all be flags of the command, unlike the top-level keys.
+func Load(path, name string) (values map[string][]string, own map[string]bool, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, nil, fmt.Errorf("%v: unknown format %+q: must be .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	values, own = map[string][]string{}, map[string]bool{}
+	add := func(table map[string]any, owned bool) error {
+		for k, v := range table {
+			if _, ok := v.(map[string]any); ok {
+				// the table of a command
+				continue
+			}
+			vs, err := toStrings(v)
+			if err != nil {
+				return fmt.Errorf("%v: %v: %w", path, k, err)
+			}
+			values[k] = vs
+			own[k] = owned
+		}
+		return nil
+	}
+	if err := add(doc, false); err != nil {
+		return nil, nil, err
+	}
+	if section, ok := doc[name].(map[string]any); ok {
+		if err := add(section, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, own, nil
+}
+
+// toStrings formats a value of a profile as flag values, one per element of a list.
+func toStrings(v any) ([]string, error) {
+	switch v := v.(type) {
+	case []any:
+		var vs []string
+		for _, e := range v {
+			if _, ok := e.([]any); ok {
+				return nil, errors.New("nested lists are not flag values")
+			}
+			s, err := toStrings(e)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, s...)
+		}
+		return vs, nil
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int:
+		return []string{strconv.Itoa(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case uint64:
+		return []string{strconv.FormatUint(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case time.Time:
+		return []string{v.Format(time.RFC3339Nano)}, nil
+	case nil:
+		return nil, errors.New("missing value")
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+}
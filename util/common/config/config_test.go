@@ -0,0 +1,158 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listValue is a flag which can be repeated, like -blocklist.
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+var profiles = map[string]string{
+	"profile.yaml": `
+dip: 1.1.1.1
+worker: 200
+timeout: 3s
+blocklist: [a.conf, b.conf]
+dipp: 2.2.2.2
+dnscensor:
+  worker: 300
+snicensor:
+  worker: 400
+`,
+	"profile.toml": `
+dip = "1.1.1.1"
+worker = 200
+timeout = "3s"
+blocklist = ["a.conf", "b.conf"]
+dipp = "2.2.2.2"
+
+[dnscensor]
+worker = 300
+
+[snicensor]
+worker = 400
+`,
+}
+
+func writeProfile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("dnscensor", flag.ContinueOnError)
+	fs.String("dip", "", "")
+	fs.Int("worker", 100, "")
+	fs.Duration("timeout", 5*time.Second, "")
+	fs.String("out", "stdout", "")
+	fs.Var(&listValue{}, "blocklist", "")
+	return fs
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "profile",
+			// the table of the command overrides the top level, and lists repeat a flag
+			want: map[string]string{"dip": "1.1.1.1", "worker": "300", "timeout": "3s", "blocklist": "a.conf,b.conf", "out": "stdout"},
+		},
+		{
+			name: "environment over profile",
+			env:  map[string]string{"DNSCENSOR_WORKER": "500", "DNSCENSOR_BLOCKLIST": "c.conf"},
+			want: map[string]string{"dip": "1.1.1.1", "worker": "500", "timeout": "3s", "blocklist": "c.conf", "out": "stdout"},
+		},
+		{
+			name: "flag over environment",
+			args: []string{"-worker", "600", "-blocklist", "d.conf", "-out", "x.csv"},
+			env:  map[string]string{"DNSCENSOR_WORKER": "500", "DNSCENSOR_TIMEOUT": "1s"},
+			want: map[string]string{"dip": "1.1.1.1", "worker": "600", "timeout": "1s", "blocklist": "d.conf", "out": "x.csv"},
+		},
+	}
+	for file, content := range profiles {
+		path := writeProfile(t, file, content)
+		for _, tt := range tests {
+			t.Run(file+"/"+tt.name, func(t *testing.T) {
+				for k, v := range tt.env {
+					t.Setenv(k, v)
+				}
+				DefaultPath = path
+				t.Cleanup(func() { DefaultPath = "" })
+				fs := newFlagSet()
+				if err := fs.Parse(tt.args); err != nil {
+					t.Fatal(err)
+				}
+				if err := Apply(fs, "dnscensor"); err != nil {
+					t.Fatal(err)
+				}
+				for name, want := range tt.want {
+					if got := fs.Lookup(name).Value.String(); got != want {
+						t.Errorf("-%v = %v, want %v", name, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	// neither environment nor profile
+	fs := newFlagSet()
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(fs, "dnscensor"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"dip": "", "worker": "100", "timeout": "5s", "blocklist": "", "out": "stdout"} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("-%v = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"unknown flag of the command", "profile.yaml", "dnscensor:\n  dipp: 1.1.1.1\n"},
+		{"unknown flag of the command", "profile.toml", "[dnscensor]\ndipp = \"1.1.1.1\"\n"},
+		{"invalid value", "profile.yaml", "worker: many\n"},
+		{"invalid value", "profile.toml", "worker = \"many\"\n"},
+		{"nested list", "profile.yaml", "blocklist: [[a.conf]]\n"},
+		{"nested list", "profile.toml", "blocklist = [[\"a.conf\"]]\n"},
+		{"missing value", "profile.yaml", "worker:\n"},
+		{"unknown format", "profile.ini", "worker=200\n"},
+	}
+	for _, tt := range tests {
+		DefaultPath = writeProfile(t, tt.file, tt.content)
+		fs := newFlagSet()
+		if err := fs.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := Apply(fs, "dnscensor"); err == nil {
+			t.Errorf("%v: %v: want an error", tt.name, tt.file)
+		}
+	}
+	DefaultPath = ""
+}
@@ -0,0 +1,331 @@
+// Package domainlist parses domain lists of various formats, such as Tranco
+// and Alexa rank CSVs and zone files, into validated names ready to probe.
+package domainlist
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"common/readfiles"
+
+	"golang.org/x/net/idna"
+)
+
+// Format is the format of a domain list.
+type Format int
+
+const (
+	// FormatAuto detects the format of every line: rank CSV if the line
+	// has a comma, zone file if it has several fields
+	// or is a directive, and plain otherwise. Zone file records continuing
+	// on the next lines in parentheses are recognized.
+	FormatAuto Format = iota
+	// FormatPlain has one domain per line, and # comments.
+	FormatPlain
+	// FormatCSV has "rank,domain" lines, such as the Tranco and Alexa lists, and # comments.
+	FormatCSV
+	// FormatZone is a zone file, of which the owner names are read.
+	FormatZone
+)
+
+var formatNames = []string{"auto", "plain", "csv", "zone"}
+
+func (f *Format) String() string {
+	if f == nil || int(*f) >= len(formatNames) {
+		return formatNames[0]
+	}
+	return formatNames[*f]
+}
+
+func (f *Format) Set(s string) error {
+	for i, name := range formatNames {
+		if s == name {
+			*f = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid format %+q: must be one of %v", s, strings.Join(formatNames, ", "))
+}
+
+const (
+	maxLabelLength = 63
+	// 255 octets in wire format, minus the length octet of the first label and the root label
+	maxNameLength = 253
+)
+
+// idnaProfile converts Unicode names to punycode. Unlike idna.Lookup, it
+// allows characters outside letters, digits and hyphens, such as the
+// underscores found in zone files, since those are valid in DNS names.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// Parser extracts names from the lines of a domain list.
+// It keeps the $ORIGIN and parentheses state of zone files across lines,
+// so a Parser must not be shared by several lists read concurrently.
+type Parser struct {
+	format Format
+	source string
+	origin string
+	// depth of open parentheses in a zone file
+	depth int
+	// number of lines parsed in the current input, to tell a CSV header
+	lines int64
+}
+
+// NewParser returns a parser of lists in format f.
+func NewParser(f Format) *Parser {
+	return &Parser{format: f}
+}
+
+// Parse returns the name in line, converted to lower-case ASCII without a trailing dot.
+// ok is false when the line has no name, eg. a blank line, a comment, a CSV header or
+// a zone file directive. err is not nil when the line has an invalid name.
+func (p *Parser) Parse(line string) (name string, ok bool, err error) {
+	p.lines++
+	format := p.format
+	if format == FormatAuto {
+		format = p.detect(line)
+	}
+	switch format {
+	case FormatCSV:
+		name, ok, err = p.parseCSV(line)
+	case FormatZone:
+		name, ok, err = p.parseZone(line)
+	default:
+		name, ok = parsePlain(line)
+	}
+	if !ok || err != nil {
+		return "", ok, err
+	}
+	name, err = Normalize(name)
+	if err != nil {
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+// ParseRecord is like Parse, but starts over the zone file and header state when rec
+// comes from another input than the previous record.
+func (p *Parser) ParseRecord(rec readfiles.Record) (name string, ok bool, err error) {
+	if rec.Source != p.source {
+		p.source = rec.Source
+		p.origin = ""
+		p.depth = 0
+		p.lines = 0
+	}
+	return p.Parse(rec.Text)
+}
+
+// Filter returns a readfiles.Options.Parse replacing the text of the records by their names,
+// so that the records are sharded, deduplicated and sampled by name. It drops the records without
+// a name, and writes those with an invalid name to rejects, unless skip reports them, eg. as done
+// by a resumed run.
+func (p *Parser) Filter(rejects *Rejects, skip func(readfiles.Record) bool) func(readfiles.Record) (readfiles.Record, bool) {
+	return func(rec readfiles.Record) (readfiles.Record, bool) {
+		name, ok, err := p.ParseRecord(rec)
+		if err != nil {
+			if !skip(rec) {
+				if err := rejects.Write(rec, err); err != nil {
+					log.Println("failed to write rejects file", err)
+				}
+			}
+			return rec, false
+		}
+		rec.Text = name
+		return rec, ok
+	}
+}
+
+// detect guesses the format of line.
+func (p *Parser) detect(line string) Format {
+	if p.depth > 0 || strings.HasPrefix(line, "$") {
+		return FormatZone
+	}
+	// commas are not found in names, but in CSV records and headers.
+	// A comma after a space is rather in the data of a zone file record.
+	if first, _, found := strings.Cut(line, ","); found && !strings.ContainsAny(strings.TrimSpace(first), " \t") {
+		return FormatCSV
+	}
+	if len(strings.Fields(stripComment(line, "#"))) > 1 {
+		return FormatZone
+	}
+	return FormatPlain
+}
+
+func parsePlain(line string) (string, bool) {
+	name := strings.TrimSpace(stripComment(line, "#"))
+	return name, name != ""
+}
+
+// parseCSV returns the domain of a "rank,domain" line. The first line of an input
+// has no name if its first field is not a rank, as it is then a header.
+func (p *Parser) parseCSV(line string) (string, bool, error) {
+	line = strings.TrimSpace(stripComment(line, "#"))
+	if line == "" {
+		return "", false, nil
+	}
+	rank, rest, found := strings.Cut(line, ",")
+	if !found {
+		return "", false, errors.New("not a rank,domain line")
+	}
+	if _, err := strconv.Atoi(strings.TrimSpace(rank)); err != nil {
+		if p.lines == 1 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("invalid rank %+q", rank)
+	}
+	name, _, _ := strings.Cut(rest, ",")
+	return name, true, nil
+}
+
+// parseZone returns the owner name of a resource record in a zone file.
+// Records continuing the previous owner, or spanning several lines in
+// parentheses, have no name of their own.
+func (p *Parser) parseZone(line string) (string, bool, error) {
+	content := stripComment(line, ";")
+	continued := p.depth > 0
+	p.depth += strings.Count(content, "(") - strings.Count(content, ")")
+	if p.depth < 0 {
+		p.depth = 0
+	}
+	if continued || strings.TrimSpace(content) == "" || isSpace(content[0]) {
+		return "", false, nil
+	}
+	fields := strings.Fields(content)
+	owner := fields[0]
+	if strings.HasPrefix(owner, "$") {
+		if strings.EqualFold(owner, "$ORIGIN") && len(fields) > 1 {
+			p.origin = strings.TrimSuffix(fields[1], ".")
+		}
+		return "", false, nil
+	}
+	if owner == "@" {
+		if p.origin == "" {
+			return "", false, errors.New("@ without $ORIGIN")
+		}
+		return p.origin, true, nil
+	}
+	if strings.HasPrefix(owner, "*.") {
+		return "", false, errors.New("wildcard owner name")
+	}
+	if !strings.HasSuffix(owner, ".") && p.origin != "" {
+		owner += "." + p.origin
+	}
+	return owner, true, nil
+}
+
+// stripComment removes the comment starting with marker outside of double quotes.
+func stripComment(line, marker string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], marker):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// Normalize converts name to lower-case ASCII, with IDNs in punycode and
+// without a trailing dot, and validates the lengths of the labels and the name.
+func Normalize(name string) (string, error) {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "", errors.New("empty name")
+	}
+	if !isASCII(name) {
+		if !utf8.ValidString(name) {
+			return "", errors.New("invalid UTF-8")
+		}
+		ascii, err := idnaProfile.ToASCII(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid IDN: %w", err)
+		}
+		name = ascii
+	}
+	name = strings.ToLower(name)
+	if len(name) > maxNameLength {
+		return "", fmt.Errorf("name longer than %v octets", maxNameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return "", errors.New("empty label")
+		}
+		if len(label) > maxLabelLength {
+			return "", fmt.Errorf("label longer than %v octets: %v", maxLabelLength, label)
+		}
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] == 0x7f {
+			return "", fmt.Errorf("invalid character %q", name[i])
+		}
+	}
+	return name, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Rejects writes the input records which are not valid names to a csv file,
+// along with the reason, instead of dropping them silently.
+// Methods on a nil *Rejects are no-ops, so that rejects are dropped when no file is given.
+type Rejects struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+// CreateRejects creates the rejects file name, or appends to it if appendMode is true.
+func CreateRejects(name string, appendMode bool) (*Rejects, error) {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendMode {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Rejects{f: f, w: csv.NewWriter(f)}, nil
+}
+
+// Write records that rec is rejected because of reason.
+// The columns are the input file, line number, text and reason.
+func (r *Rejects) Write(rec readfiles.Record, reason error) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Write([]string{rec.Source, strconv.FormatInt(rec.Line, 10), rec.Text, reason.Error()})
+	r.w.Flush()
+	return err
+}
+
+// Close flushes and closes the rejects file.
+func (r *Rejects) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+	return r.f.Close()
+}
@@ -0,0 +1,135 @@
+package parseipportargs
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Clone returns a copy of the set.
+func (s *IPSet) Clone() *IPSet {
+	return &IPSet{ranges: slices.Clone(s.ranges)}
+}
+
+// Union returns the addresses in s or o.
+func (s *IPSet) Union(o *IPSet) *IPSet {
+	ranges := make([]IPRange, 0, len(s.ranges)+len(o.ranges))
+	ranges = append(ranges, s.ranges...)
+	ranges = append(ranges, o.ranges...)
+	slices.SortFunc(ranges, func(a, b IPRange) int { return compareAddr(a.First, b.First) })
+	// merge the ranges which overlap or touch
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if compareAddr(r.First, last.Last) <= 0 || last.Last.Next() == r.First {
+				if compareAddr(r.Last, last.Last) > 0 {
+					last.Last = r.Last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return &IPSet{ranges: merged}
+}
+
+// Intersection returns the addresses in both s and o.
+func (s *IPSet) Intersection(o *IPSet) *IPSet {
+	var ranges []IPRange
+	for i, j := 0, 0; i < len(s.ranges) && j < len(o.ranges); {
+		a, b := s.ranges[i], o.ranges[j]
+		first, last := a.First, a.Last
+		if compareAddr(b.First, first) > 0 {
+			first = b.First
+		}
+		if compareAddr(b.Last, last) < 0 {
+			last = b.Last
+		}
+		if compareAddr(first, last) <= 0 {
+			ranges = append(ranges, IPRange{First: first, Last: last})
+		}
+		// move past the range which ends first
+		if compareAddr(a.Last, b.Last) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return &IPSet{ranges: ranges}
+}
+
+// Difference returns the addresses in s but not in o.
+func (s *IPSet) Difference(o *IPSet) *IPSet {
+	var ranges []IPRange
+	j := 0
+	for _, a := range s.ranges {
+		// skip the ranges of o before a
+		for j < len(o.ranges) && compareAddr(o.ranges[j].Last, a.First) < 0 {
+			j++
+		}
+		// cut the ranges of o overlapping a out of it
+		for k := j; k < len(o.ranges) && compareAddr(o.ranges[k].First, a.Last) <= 0; k++ {
+			b := o.ranges[k]
+			if compareAddr(b.First, a.First) > 0 {
+				ranges = append(ranges, IPRange{First: a.First, Last: b.First.Prev()})
+			}
+			if compareAddr(b.Last, a.Last) >= 0 {
+				a.First = netip.Addr{}
+				break
+			}
+			a.First = b.Last.Next()
+		}
+		if a.First.IsValid() {
+			ranges = append(ranges, a)
+		}
+	}
+	return &IPSet{ranges: ranges}
+}
+
+// ContainsSet reports whether every address in o is in s.
+func (s *IPSet) ContainsSet(o *IPSet) bool {
+	return o.Difference(s).IsEmpty()
+}
+
+// Equal reports whether s and o have the same addresses.
+func (s *IPSet) Equal(o *IPSet) bool {
+	return slices.Equal(s.ranges, o.ranges)
+}
+
+// ParseIPRanges parses a comma-separated list of IPs, CIDRs and ranges, as written by IPSet.String.
+// Unlike ParseIPSet, it neither resolves hostnames, reads files, nor removes exclusions.
+func ParseIPRanges(s string) (*IPSet, error) {
+	set := &IPSet{}
+	if err := set.Set(s); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Set adds the addresses in the comma-separated list s to the set, as parsed by ParseIPRanges,
+// so that *IPSet is a flag.Value.
+func (s *IPSet) Set(v string) error {
+	for _, term := range strings.Split(v, ",") {
+		if term = strings.TrimSpace(term); term == "" {
+			continue
+		}
+		r, err := ParseIPRange(term)
+		if err != nil {
+			return err
+		}
+		s.AddRange(r)
+	}
+	return nil
+}
+
+// MarshalText writes the set as String does.
+func (s *IPSet) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses the set as ParseIPRanges does.
+func (s *IPSet) UnmarshalText(text []byte) error {
+	*s = IPSet{}
+	return s.Set(string(text))
+}
@@ -0,0 +1,136 @@
+package parseipportargs
+
+import "testing"
+
+func mustParseIPRanges(t *testing.T, s string) *IPSet {
+	t.Helper()
+	set, err := ParseIPRanges(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestIPSetAlgebra(t *testing.T) {
+	tests := []struct {
+		name                     string
+		s, o                     string
+		union, inter, difference string
+	}{
+		{
+			name: "empty",
+			s:    "", o: "10.0.0.0/8",
+			union: "10.0.0.0/8", inter: "", difference: "",
+		},
+		{
+			name: "disjoint",
+			s:    "10.0.0.1", o: "10.0.0.3",
+			union: "10.0.0.1,10.0.0.3", inter: "", difference: "10.0.0.1",
+		},
+		{
+			name: "touching",
+			s:    "10.0.0.0-10.0.0.4", o: "10.0.0.5-10.0.0.7",
+			union: "10.0.0.0/29", inter: "", difference: "10.0.0.0-10.0.0.4",
+		},
+		{
+			name: "sharing the last address",
+			s:    "10.0.0.0-10.0.0.5", o: "10.0.0.5-10.0.0.7",
+			union: "10.0.0.0/29", inter: "10.0.0.5", difference: "10.0.0.0-10.0.0.4",
+		},
+		{
+			name: "sharing the first address",
+			s:    "10.0.0.5-10.0.0.7", o: "10.0.0.0-10.0.0.5",
+			union: "10.0.0.0/29", inter: "10.0.0.5", difference: "10.0.0.6/31",
+		},
+		{
+			name: "inside",
+			s:    "10.0.0.0/24", o: "10.0.0.1,10.0.0.254",
+			union: "10.0.0.0/24", inter: "10.0.0.1,10.0.0.254", difference: "10.0.0.0,10.0.0.2-10.0.0.253,10.0.0.255",
+		},
+		{
+			name: "equal",
+			s:    "10.0.0.0/24", o: "10.0.0.0-10.0.0.255",
+			union: "10.0.0.0/24", inter: "10.0.0.0/24", difference: "",
+		},
+		{
+			name: "spanning several ranges",
+			s:    "10.0.0.0/28", o: "9.0.0.0-10.0.0.1,10.0.0.4-10.0.0.5,10.0.0.15-11.0.0.0",
+			union: "9.0.0.0-11.0.0.0", inter: "10.0.0.0/31,10.0.0.4/31,10.0.0.15", difference: "10.0.0.2/31,10.0.0.6-10.0.0.14",
+		},
+		{
+			name: "ends of the address space",
+			s:    "0.0.0.0,255.255.255.255,::,ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", o: "0.0.0.0/0",
+			union: "0.0.0.0/0,::,ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", inter: "0.0.0.0,255.255.255.255", difference: "::,ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+		},
+		{
+			// ::ffff:10.0.0.1 is an IPv6 address, not 10.0.0.1
+			name: "families",
+			s:    "10.0.0.0/24", o: "::ffff:10.0.0.0/120,::",
+			union: "10.0.0.0/24,::,::ffff:10.0.0.0/120", inter: "", difference: "10.0.0.0/24",
+		},
+		{
+			name: "families adjacent",
+			s:    "255.255.255.255", o: "::",
+			union: "255.255.255.255,::", inter: "", difference: "255.255.255.255",
+		},
+		{
+			name: "zones",
+			s:    "fe80::%eth0/126,fe80::1", o: "fe80::1%eth0,fe80::%eth1/126",
+			union: "fe80::1,fe80::%eth0/126,fe80::%eth1/126", inter: "fe80::1%eth0", difference: "fe80::1,fe80::%eth0,fe80::2%eth0/127",
+		},
+		{
+			name: "zones touching",
+			s:    "fe80::%br-lan-fe80::1%br-lan", o: "fe80::2%br-lan-fe80::3%br-lan",
+			union: "fe80::%br-lan/126", inter: "", difference: "fe80::%br-lan/127",
+		},
+	}
+	for _, tt := range tests {
+		s, o := mustParseIPRanges(t, tt.s), mustParseIPRanges(t, tt.o)
+		sBefore, oBefore := s.String(), o.String()
+		if got := s.Union(o).String(); got != tt.union {
+			t.Errorf("%v: %v ∪ %v = %v, want %v", tt.name, s, o, got, tt.union)
+		}
+		if got := o.Union(s).String(); got != tt.union {
+			t.Errorf("%v: %v ∪ %v = %v, want %v", tt.name, o, s, got, tt.union)
+		}
+		if got := s.Intersection(o).String(); got != tt.inter {
+			t.Errorf("%v: %v ∩ %v = %v, want %v", tt.name, s, o, got, tt.inter)
+		}
+		if got := o.Intersection(s).String(); got != tt.inter {
+			t.Errorf("%v: %v ∩ %v = %v, want %v", tt.name, o, s, got, tt.inter)
+		}
+		if got := s.Difference(o).String(); got != tt.difference {
+			t.Errorf("%v: %v ∖ %v = %v, want %v", tt.name, s, o, got, tt.difference)
+		}
+		// the results are normalized like the sets built by AddRange
+		if u := s.Union(o); !u.Equal(mustParseIPRanges(t, tt.union)) {
+			t.Errorf("%v: %v ∪ %v has ranges %v, want those of %v", tt.name, s, o, u.Ranges(), tt.union)
+		}
+		if !s.Union(o).ContainsSet(s) || !s.ContainsSet(s.Intersection(o)) || !s.ContainsSet(s.Difference(o)) {
+			t.Errorf("%v: inconsistent ContainsSet", tt.name)
+		}
+		if s.String() != sBefore || o.String() != oBefore {
+			t.Errorf("%v: the operands were modified", tt.name)
+		}
+	}
+}
+
+func TestIPSetText(t *testing.T) {
+	for _, s := range []string{"", "10.0.0.0/8", "1.1.1.1-1.1.1.9,2001:db8::/32", "fe80::%br-lan/64,fe80::1%eth0"} {
+		set := mustParseIPRanges(t, s)
+		b, err := set.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("MarshalText() = %v, want %v", string(b), s)
+		}
+		var got IPSet
+		if err := got.UnmarshalText(b); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(set) {
+			t.Errorf("UnmarshalText(%v) = %v", s, &got)
+		}
+	}
+}
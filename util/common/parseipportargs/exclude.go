@@ -0,0 +1,179 @@
+package parseipportargs
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// reservedCIDRs are the networks which are not globally reachable: private, shared,
+// loopback, link-local, documentation, benchmarking, multicast and reserved ones.
+// https://www.iana.org/assignments/iana-ipv4-special-registry
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var reservedCIDRs = []string{
+	"0.0.0.0/8",       // "this" network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // shared address space, ie. carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation, TEST-NET-1
+	"192.88.99.0/24",  // deprecated 6to4 relay anycast
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation, TEST-NET-2
+	"203.0.113.0/24",  // documentation, TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, and limited broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"::ffff:0:0/96",   // IPv4-mapped
+	"64:ff9b:1::/48",  // local-use IPv4/IPv6 translation
+	"100::/64",        // discard-only
+	"2001::/23",       // IETF protocol assignments
+	"2001:db8::/32",   // documentation
+	"3fff::/20",       // documentation
+	"5f00::/16",       // segment routing SIDs
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link-local
+	"ff00::/8",        // multicast
+}
+
+// Reserved is the set of the networks which are not globally reachable.
+var Reserved = mustParseCIDRs(reservedCIDRs)
+
+func mustParseCIDRs(cidrs []string) *IPSet {
+	set := &IPSet{}
+	for _, cidr := range cidrs {
+		set.AddPrefix(netip.MustParsePrefix(cidr))
+	}
+	return set
+}
+
+// Exclusions are the addresses which must never be probed, for ethical reasons or
+// because their operators opted out. The zero value excludes the Reserved networks only.
+type Exclusions struct {
+	// Set is the set of the excluded addresses, in addition to Reserved.
+	Set IPSet
+	// AllowReserved does not exclude the Reserved networks.
+	AllowReserved bool
+}
+
+// DefaultExclusions are removed from every set parsed by ParseIPArgs and ParseIPSet.
+// RegisterFlags binds them to command-line flags.
+var DefaultExclusions Exclusions
+
+// Add excludes a comma-separated list of IPs, CIDRs and ranges.
+func (e *Exclusions) Add(s string) error {
+	for _, ipOrCidr := range strings.Split(s, ",") {
+		if err := e.add(strings.TrimSpace(ipOrCidr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Exclusions) add(term string) error {
+	r, err := ParseIPRange(term)
+	if err != nil {
+		return err
+	}
+	e.Set.AddRange(r)
+	return nil
+}
+
+// AddFile excludes the IPs, CIDRs and ranges listed in a file in the format of zmap's
+// blocklist.conf: one per line, with # comments and blank lines.
+func (e *Exclusions) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := e.add(entry); err != nil {
+			return fmt.Errorf("%v:%v: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Contains reports whether ip is excluded, or has the wrong family for DefaultFamily.
+// Exclusions without a zone apply to the addresses in any zone.
+func (e *Exclusions) Contains(ip netip.Addr) bool {
+	if !DefaultFamily.Match(ip) {
+		return true
+	}
+	for _, a := range []netip.Addr{ip, ip.WithZone("")} {
+		if e.Set.Contains(a) || (!e.AllowReserved && Reserved.Contains(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply removes the excluded addresses, and those of the wrong family for DefaultFamily, from set.
+func (e *Exclusions) Apply(set *IPSet) {
+	set.ranges = set.Difference(e.excluded(set)).ranges
+}
+
+// excluded returns the set of addresses to remove from set: the exclusions, copied into
+// each zone of set when they have none, and the ranges of the wrong family.
+func (e *Exclusions) excluded(set *IPSet) *IPSet {
+	excluded := e.Set.Clone()
+	if !e.AllowReserved {
+		excluded = excluded.Union(Reserved)
+	}
+	unzoned := excluded.Clone().Ranges()
+	for _, zone := range set.zones() {
+		for _, r := range unzoned {
+			if r.First.Zone() == "" {
+				excluded.AddRange(r.withZone(zone))
+			}
+		}
+	}
+	for _, r := range set.Ranges() {
+		if !DefaultFamily.Match(r.First) {
+			excluded.AddRange(r)
+		}
+	}
+	return excluded
+}
+
+// Family selects the addresses of one family.
+type Family int
+
+const (
+	AnyFamily Family = iota
+	IPv4
+	IPv6
+)
+
+// DefaultFamily is the family of the addresses kept by ParseIPArgs and ParseIPSet,
+// and of the hostnames resolved by DefaultResolver. RegisterFlags binds it to -4 and -6.
+var DefaultFamily Family
+
+// Match reports whether ip is of family f.
+func (f Family) Match(ip netip.Addr) bool {
+	switch f {
+	case IPv4:
+		return ip.Is4()
+	case IPv6:
+		return ip.Is6()
+	default:
+		return true
+	}
+}
+
+func errExcluded(s string) error {
+	return fmt.Errorf("all addresses in %+q are excluded, see -exclude-ip, -blocklist, -allow-reserved, -4 and -6", s)
+}
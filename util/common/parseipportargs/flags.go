@@ -0,0 +1,47 @@
+package parseipportargs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// RegisterFlags defines the command-line flags that configure DefaultExclusions, DefaultResolver, DefaultFamily and DefaultIPDB.
+// usage example: in external function, RegisterFlags(flag.CommandLine) before flag.Parse()
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.Func("exclude-ip", "comma-separated list of IPs, CIDRs and ranges never to probe, in addition to the reserved networks. Can be repeated.", DefaultExclusions.Add)
+	fs.Func("blocklist", "never probe the IPs and CIDRs listed in `file`, in the format of zmap's blocklist.conf. Can be repeated.", DefaultExclusions.AddFile)
+	fs.BoolVar(&DefaultExclusions.AllowReserved, "allow-reserved", false, "allow probing private, loopback, link-local, multicast and other reserved networks, which are excluded by default.")
+	fs.StringVar(&DefaultResolver.Server, "resolver", "", "resolve the hostnames among IPs with the DNS server at `ip[:port]`. (default the system resolver)")
+	fs.StringVar(&DefaultResolver.HostsFile, "hosts-file", "", "resolve the hostnames among IPs with a `file` in the format of /etc/hosts, instead of DNS.")
+	fs.Func("resolve-family", "resolve hostnames to IPv4 (ip4), IPv6 (ip6) or both (ip) addresses. (default following -4 and -6)", func(s string) error {
+		if s != "ip" && s != "ip4" && s != "ip6" {
+			return fmt.Errorf("invalid family %+q: must be one of ip, ip4, ip6", s)
+		}
+		DefaultResolver.Network = s
+		return nil
+	})
+	fs.Var(DefaultIPDB, "ipdb", "offline IP database `file` for the asn:N and cc:XX selectors of IPs, and for the AS and country columns of the output: a MaxMind .mmdb file, or an iptoasn .tsv file. Can be repeated, eg. with an ASN and a country database.")
+	fs.BoolFunc("4", "use IPv4 targets only.", func(s string) error { return setFamily(IPv4, s) })
+	fs.BoolFunc("6", "use IPv6 targets only.", func(s string) error { return setFamily(IPv6, s) })
+}
+
+// setFamily restricts DefaultFamily to f if s is true, or lifts the restriction to f if s is false, eg. for -4=false.
+func setFamily(f Family, s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if !on {
+		if DefaultFamily == f {
+			DefaultFamily = AnyFamily
+		}
+		return nil
+	}
+	if DefaultFamily != AnyFamily && DefaultFamily != f {
+		return errors.New("-4 and -6 are mutually exclusive")
+	}
+	DefaultFamily = f
+	return nil
+}
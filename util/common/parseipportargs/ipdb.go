@@ -0,0 +1,272 @@
+package parseipportargs
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"common/readfiles"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Origin is the AS and the country of an IP.
+type Origin struct {
+	ASN     uint32
+	Country string
+}
+
+// String formats an origin as "AS4134 CN", leaving out the unknown parts.
+func (o Origin) String() string {
+	var parts []string
+	if o.ASN != 0 {
+		parts = append(parts, "AS"+strconv.FormatUint(uint64(o.ASN), 10))
+	}
+	if o.Country != "" {
+		parts = append(parts, o.Country)
+	}
+	return strings.Join(parts, " ")
+}
+
+// IPDB selects and annotates IPs by AS and country, with offline databases: MaxMind MMDB files,
+// eg. GeoLite2-ASN.mmdb and GeoLite2-Country.mmdb, or iptoasn TSV files, eg. ip2asn-combined.tsv.gz.
+// The databases are loaded on first use. When several give an origin for an IP, the first one wins,
+// part by part, so an ASN database and a country database can be combined.
+type IPDB struct {
+	Paths []string
+
+	once   sync.Once
+	err    error
+	mmdbs  []*maxminddb.Reader
+	tables [][]originRange
+}
+
+// DefaultIPDB is the database of the asn: and cc: selectors parsed by ParseIPArgs and ParseIPSet.
+// RegisterFlags binds it to -ipdb.
+var DefaultIPDB = &IPDB{}
+
+// originRange is a row of an iptoasn file.
+type originRange struct {
+	IPRange
+	Origin
+}
+
+// mmdbRecord has the fields of the GeoLite2/GeoIP2 ASN, Country and City databases which make an Origin.
+type mmdbRecord struct {
+	ASN     uint32 `maxminddb:"autonomous_system_number"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+func (r mmdbRecord) origin() Origin {
+	return Origin{ASN: r.ASN, Country: r.Country.ISOCode}
+}
+
+// String returns the comma-separated paths of the databases, so that *IPDB is a flag.Value.
+func (db *IPDB) String() string {
+	if db == nil {
+		return ""
+	}
+	return strings.Join(db.Paths, ",")
+}
+
+// Set adds the comma-separated database paths in s.
+func (db *IPDB) Set(s string) error {
+	for _, path := range strings.Split(s, ",") {
+		if path != "" {
+			db.Paths = append(db.Paths, path)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether db has any database.
+func (db *IPDB) Enabled() bool {
+	return db != nil && len(db.Paths) > 0
+}
+
+// Load opens the databases, which is otherwise done on first use, to report their errors early.
+func (db *IPDB) Load() error {
+	db.once.Do(func() {
+		for _, path := range db.Paths {
+			if filepath.Ext(path) == ".mmdb" {
+				r, err := maxminddb.Open(path)
+				if err != nil {
+					db.err = fmt.Errorf("failed to open IP database %v: %w", path, err)
+					return
+				}
+				db.mmdbs = append(db.mmdbs, r)
+				continue
+			}
+			table, err := readIPToASN(path)
+			if err != nil {
+				db.err = err
+				return
+			}
+			log.Println("loaded", len(table), "ranges from", path)
+			db.tables = append(db.tables, table)
+		}
+	})
+	return db.err
+}
+
+// readIPToASN reads an iptoasn file, whose tab-separated columns are the first IP, the last IP,
+// the AS number, the country code and the AS description, sorted by IP.
+// The ranges which are not routed have AS number 0 and country None.
+func readIPToASN(path string) ([]originRange, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// stop reading on error
+	defer cancel()
+	records, errs := readfiles.ReadRecords(ctx, []string{path}, readfiles.Options{})
+	var table []originRange
+	for rec := range records {
+		fields := strings.Split(rec.Text, "\t")
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%v:%v: expected at least 4 tab-separated fields", rec.Source, rec.Line)
+		}
+		first, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", rec.Source, rec.Line, err)
+		}
+		last, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", rec.Source, rec.Line, err)
+		}
+		r, err := NewIPRange(first, last)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", rec.Source, rec.Line, err)
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: invalid AS number: %w", rec.Source, rec.Line, err)
+		}
+		o := Origin{ASN: uint32(asn), Country: fields[3]}
+		if o.Country == "None" {
+			o.Country = ""
+		}
+		if o != (Origin{}) {
+			table = append(table, originRange{r, o})
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if len(table) == 0 {
+		return nil, fmt.Errorf("no ranges in IP database %v", path)
+	}
+	slices.SortFunc(table, func(a, b originRange) int { return compareAddr(a.First, b.First) })
+	return table, nil
+}
+
+// Lookup returns the origin of ip, which is zero if no database knows it.
+func (db *IPDB) Lookup(ip netip.Addr) (Origin, error) {
+	var o Origin
+	if err := db.Load(); err != nil {
+		return o, err
+	}
+	ip = ip.WithZone("")
+	merge := func(found Origin) {
+		o.ASN = cmp.Or(o.ASN, found.ASN)
+		o.Country = cmp.Or(o.Country, found.Country)
+	}
+	for _, r := range db.mmdbs {
+		var rec mmdbRecord
+		if err := r.Lookup(net.IP(ip.AsSlice()), &rec); err != nil {
+			return o, err
+		}
+		merge(rec.origin())
+	}
+	for _, table := range db.tables {
+		i, found := slices.BinarySearchFunc(table, ip, func(r originRange, ip netip.Addr) int {
+			return compareAddr(r.First, ip)
+		})
+		if !found {
+			i--
+		}
+		if i >= 0 && table[i].Contains(ip) {
+			merge(table[i].Origin)
+		}
+	}
+	return o, nil
+}
+
+// isSelector reports whether term is an asn: or cc: selector.
+func isSelector(term string) bool {
+	return strings.HasPrefix(term, "asn:") || strings.HasPrefix(term, "cc:")
+}
+
+// parseSelector returns whether an origin matches selector.
+func parseSelector(selector string) (func(Origin) bool, error) {
+	kind, value, _ := strings.Cut(selector, ":")
+	switch kind {
+	case "asn":
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil || asn == 0 {
+			return nil, fmt.Errorf("invalid AS number in %+q", selector)
+		}
+		return func(o Origin) bool { return o.ASN == uint32(asn) }, nil
+	case "cc":
+		if len(value) != 2 {
+			return nil, fmt.Errorf("invalid country code in %+q: must have 2 letters", selector)
+		}
+		cc := strings.ToUpper(value)
+		return func(o Origin) bool { return o.Country == cc }, nil
+	}
+	return nil, fmt.Errorf("unknown selector %+q: must be asn:N or cc:XX", selector)
+}
+
+// Select returns the IPs matching a selector: asn:N (or asn:ASN) for the IPs announced by AS N,
+// or cc:XX for the IPs located in the country with ISO 3166 code XX.
+func (db *IPDB) Select(selector string) (*IPSet, error) {
+	match, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if !db.Enabled() {
+		return nil, fmt.Errorf("no IP database for %+q, see -ipdb", selector)
+	}
+	if err := db.Load(); err != nil {
+		return nil, err
+	}
+	set := &IPSet{}
+	for _, r := range db.mmdbs {
+		networks := r.Networks(maxminddb.SkipAliasedNetworks)
+		for networks.Next() {
+			var rec mmdbRecord
+			network, err := networks.Network(&rec)
+			if err != nil {
+				return nil, err
+			}
+			if !match(rec.origin()) {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(network.IP)
+			bits, _ := network.Mask.Size()
+			set.AddPrefix(netip.PrefixFrom(ip.Unmap(), bits))
+		}
+		if err := networks.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, table := range db.tables {
+		for _, r := range table {
+			if match(r.Origin) {
+				set.AddRange(r.IPRange)
+			}
+		}
+	}
+	if set.IsEmpty() {
+		return nil, fmt.Errorf("no IP matches %+q in %v", selector, db)
+	}
+	log.Println("selected", set.Len(), "IPs in", len(set.Ranges()), "ranges for", selector)
+	return set, nil
+}
@@ -0,0 +1,274 @@
+package parseipportargs
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// IPRange is an inclusive range of IP addresses of the same family and IPv6 zone.
+type IPRange struct {
+	First, Last netip.Addr
+}
+
+// NewIPRange returns the range from first to last, which must be of the same family.
+func NewIPRange(first, last netip.Addr) (IPRange, error) {
+	if first.BitLen() != last.BitLen() {
+		return IPRange{}, fmt.Errorf("%v and %v are not of the same family", first, last)
+	}
+	if first.Zone() != last.Zone() {
+		return IPRange{}, fmt.Errorf("%v and %v are not in the same zone", first, last)
+	}
+	if compareAddr(first, last) > 0 {
+		return IPRange{}, fmt.Errorf("%v is higher than %v", first, last)
+	}
+	return IPRange{First: first, Last: last}, nil
+}
+
+// PrefixRange returns the range of the addresses in p.
+func PrefixRange(p netip.Prefix) IPRange {
+	p = p.Masked()
+	return IPRange{First: p.Addr(), Last: prefixLast(p)}
+}
+
+// prefixLast returns the last address in the masked prefix p.
+func prefixLast(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	last, _ := netip.AddrFromSlice(b)
+	return last
+}
+
+// Len returns the number of addresses in the range, which does not fit in an int64 for large IPv6 ranges.
+func (r IPRange) Len() *big.Int {
+	n := new(big.Int).Sub(addrInt(r.Last), addrInt(r.First))
+	return n.Add(n, big.NewInt(1))
+}
+
+func (r IPRange) Contains(ip netip.Addr) bool {
+	return compareAddr(r.First, ip) <= 0 && compareAddr(ip, r.Last) <= 0
+}
+
+// String returns the range as a CIDR if it is one, eg. fe80::%eth0/64 in a zone, and as first-last otherwise.
+func (r IPRange) String() string {
+	if r.First == r.Last {
+		return r.First.String()
+	}
+	first := r.First.WithZone("")
+	last := r.Last.WithZone("")
+	for bits := 0; bits <= first.BitLen(); bits++ {
+		p := netip.PrefixFrom(first, bits)
+		if p.Masked().Addr() == first && prefixLast(p) == last {
+			return r.First.String() + "/" + strconv.Itoa(bits)
+		}
+	}
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// compareAddr orders addresses by family, then by IPv6 zone, then by address,
+// so that the addresses of a zone are contiguous and ranges never span zones.
+func compareAddr(a, b netip.Addr) int {
+	if c := cmp.Compare(a.BitLen(), b.BitLen()); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Zone(), b.Zone()); c != 0 {
+		return c
+	}
+	return a.WithZone("").Compare(b.WithZone(""))
+}
+
+// withZone returns r in zone.
+func (r IPRange) withZone(zone string) IPRange {
+	return IPRange{First: r.First.WithZone(zone), Last: r.Last.WithZone(zone)}
+}
+
+func addrInt(ip netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(ip.AsSlice())
+}
+
+// IPSet is a set of IP addresses, stored as sorted, disjoint ranges, so that it
+// takes the same memory for 10.0.0.0/8 or any IPv6 prefix as for a single address.
+// The zero value is an empty set.
+type IPSet struct {
+	ranges []IPRange
+}
+
+// NewIPSet returns the set of the addresses in ranges.
+func NewIPSet(ranges ...IPRange) *IPSet {
+	s := &IPSet{}
+	for _, r := range ranges {
+		s.AddRange(r)
+	}
+	return s
+}
+
+// AddRange adds the addresses in r to the set, merging it with the ranges it overlaps or touches.
+func (s *IPSet) AddRange(r IPRange) {
+	// first range which is not entirely before r
+	i, _ := slices.BinarySearchFunc(s.ranges, r.First, func(x IPRange, first netip.Addr) int {
+		if compareAddr(x.Last, first) < 0 && x.Last.Next() != first {
+			return -1
+		}
+		return 1
+	})
+	j := i
+	for ; j < len(s.ranges) && (compareAddr(s.ranges[j].First, r.Last) <= 0 || r.Last.Next() == s.ranges[j].First); j++ {
+		if compareAddr(s.ranges[j].First, r.First) < 0 {
+			r.First = s.ranges[j].First
+		}
+		if compareAddr(s.ranges[j].Last, r.Last) > 0 {
+			r.Last = s.ranges[j].Last
+		}
+	}
+	s.ranges = slices.Replace(s.ranges, i, j, r)
+}
+
+// RemoveRange removes the addresses in r from the set.
+func (s *IPSet) RemoveRange(r IPRange) {
+	ranges := make([]IPRange, 0, len(s.ranges)+1)
+	for _, x := range s.ranges {
+		if compareAddr(x.Last, r.First) < 0 || compareAddr(x.First, r.Last) > 0 {
+			ranges = append(ranges, x)
+			continue
+		}
+		if compareAddr(x.First, r.First) < 0 {
+			ranges = append(ranges, IPRange{First: x.First, Last: r.First.Prev()})
+		}
+		if compareAddr(x.Last, r.Last) > 0 {
+			ranges = append(ranges, IPRange{First: r.Last.Next(), Last: x.Last})
+		}
+	}
+	s.ranges = ranges
+}
+
+// AddPrefix adds the addresses in p to the set.
+func (s *IPSet) AddPrefix(p netip.Prefix) {
+	s.AddRange(PrefixRange(p))
+}
+
+// Add adds ip to the set.
+func (s *IPSet) Add(ip netip.Addr) {
+	s.AddRange(IPRange{First: ip, Last: ip})
+}
+
+// Ranges returns the sorted, disjoint ranges of the set. It must not be modified.
+func (s *IPSet) Ranges() []IPRange {
+	return s.ranges
+}
+
+// zones returns the IPv6 zones of the addresses in the set.
+func (s *IPSet) zones() []string {
+	var zones []string
+	for _, r := range s.ranges {
+		if z := r.First.Zone(); z != "" && !slices.Contains(zones, z) {
+			zones = append(zones, z)
+		}
+	}
+	return zones
+}
+
+// Len returns the number of addresses in the set.
+func (s *IPSet) Len() *big.Int {
+	n := new(big.Int)
+	for _, r := range s.ranges {
+		n.Add(n, r.Len())
+	}
+	return n
+}
+
+func (s *IPSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+func (s *IPSet) Contains(ip netip.Addr) bool {
+	i, found := slices.BinarySearchFunc(s.ranges, ip, func(r IPRange, ip netip.Addr) int {
+		return compareAddr(r.First, ip)
+	})
+	if found {
+		return true
+	}
+	return i > 0 && s.ranges[i-1].Contains(ip)
+}
+
+func (s *IPSet) String() string {
+	terms := make([]string, len(s.ranges))
+	for i, r := range s.ranges {
+		terms[i] = r.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// Iterator returns an iterator over the addresses in the set, in ascending order.
+// It takes constant memory, whatever the size of the set.
+func (s *IPSet) Iterator() *IPIterator {
+	return &IPIterator{ranges: s.ranges}
+}
+
+// All returns the addresses in the set, in ascending order.
+// usage example: in external function, for ip := range set.All() { ... }
+func (s *IPSet) All() iter.Seq[netip.Addr] {
+	return func(yield func(netip.Addr) bool) {
+		it := s.Iterator()
+		for ip, ok := it.Next(); ok; ip, ok = it.Next() {
+			if !yield(ip) {
+				return
+			}
+		}
+	}
+}
+
+// IPIterator iterates over the addresses of an IPSet.
+type IPIterator struct {
+	ranges []IPRange
+	// index of the current range, and next address in it
+	i    int
+	next netip.Addr
+}
+
+// Next returns the next address, or false when all addresses have been returned.
+func (it *IPIterator) Next() (netip.Addr, bool) {
+	if it.i >= len(it.ranges) {
+		return netip.Addr{}, false
+	}
+	r := it.ranges[it.i]
+	if !it.next.IsValid() {
+		it.next = r.First
+	}
+	ip := it.next
+	if ip == r.Last {
+		it.i++
+		it.next = netip.Addr{}
+	} else {
+		it.next = ip.Next()
+	}
+	return ip, true
+}
+
+// Reset starts the iteration over from the first address.
+func (it *IPIterator) Reset() {
+	it.i = 0
+	it.next = netip.Addr{}
+}
+
+// ParseIPSet is like ParseIPArgs, but returns the addresses as a set, without expanding the CIDRs and ranges.
+// The addresses in DefaultExclusions are removed, and it is an error if none is left.
+// usage example: in external function, set, err := ParseIPSet("1.0.0.0/8,2001:db8::/32")
+func ParseIPSet(s string) (*IPSet, error) {
+	set := &IPSet{}
+	err := parseIPTerms(s, set.AddRange)
+	if err != nil {
+		return nil, err
+	}
+	DefaultExclusions.Apply(set)
+	if set.IsEmpty() {
+		return nil, errExcluded(s)
+	}
+	return set, nil
+}
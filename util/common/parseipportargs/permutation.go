@@ -0,0 +1,218 @@
+package parseipportargs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// maxPermutationSize is the largest number of ip:port pairs a Permutation can permute,
+// which keeps the arithmetic modulo the prime in 64 bits. Larger products, eg. of an IPv6 /64,
+// are walked in order.
+const maxPermutationSize = 1 << 62
+
+// Permutation walks the product of a set of IPs and a list of ports in a pseudorandom
+// order, like zmap: it iterates over the multiplicative group of integers modulo a prime p
+// larger than the number of pairs, by multiplying by a primitive root g, so that every pair is
+// visited exactly once, with O(1) memory whatever the size of the set.
+//
+// The order is decided by a seed, so that it can be reproduced, and can be split into
+// shards, eg. one per sender. Every shard must be created with the same seed.
+type Permutation struct {
+	ips    []IPRange
+	starts []uint64 // number of IPs before every range
+	ports  *PortSet
+	size   uint64
+	shards uint64
+
+	// above maxPermutationSize, the pairs are walked in order, from the IP of range r
+	// and the port at index port, and the shard keeps one pair every shards after skipping skip
+	inOrder bool
+	r       int
+	ip      netip.Addr
+	port    int
+	skip    uint64
+
+	prime uint64
+	// step between the elements of the shard, ie. g to the power of the number of shards
+	step uint64
+	// current element of the group, and its position in the whole cycle
+	cur uint64
+	pos uint64
+}
+
+// NewPermutation returns shard k of n of the permutation of ips × ports seeded by seed.
+// A zero seed picks a random one, which is logged so that the order can be reproduced.
+func NewPermutation(ips *IPSet, ports *PortSet, seed int64, k, n int) (*Permutation, error) {
+	if n < 1 || k < 0 || k >= n {
+		return nil, fmt.Errorf("invalid shard %v/%v: must be 0 <= k < n", k, n)
+	}
+	numIPs := ips.Len()
+	size := new(big.Int).Mul(numIPs, big.NewInt(int64(ports.Len())))
+	if size.Sign() == 0 {
+		return nil, errors.New("no ip:port to permute")
+	}
+	if size.Cmp(big.NewInt(maxPermutationSize)) > 0 {
+		log.Printf("too many ip:port pairs to permute: %v, over %v, walking them in order", size, uint64(maxPermutationSize))
+		ranges := ips.Ranges()
+		return &Permutation{ips: ranges, ports: ports, size: math.MaxUint64, shards: uint64(n), inOrder: true, ip: ranges[0].First, skip: uint64(k)}, nil
+	}
+	p := &Permutation{
+		ips:    ips.Ranges(),
+		starts: make([]uint64, len(ips.Ranges())),
+		ports:  ports,
+		size:   size.Uint64(),
+		shards: uint64(n),
+	}
+	var before uint64
+	for i, r := range p.ips {
+		p.starts[i] = before
+		before += r.Len().Uint64()
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+		log.Println("permuting targets with random seed", seed)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	p.prime = safePrimeAbove(p.size)
+	g := primitiveRoot(p.prime, rng)
+	// a random element to start from, then the first element of the shard
+	start := uint64(rng.Int63n(int64(p.prime-1))) + 1
+	p.cur = mulMod(start, powMod(g, uint64(k), p.prime), p.prime)
+	p.pos = uint64(k)
+	p.step = powMod(g, uint64(n), p.prime)
+	return p, nil
+}
+
+// Len returns the number of ip:port pairs of all shards, or math.MaxUint64 if they are walked in order.
+func (p *Permutation) Len() uint64 {
+	return p.size
+}
+
+// Next returns the next ip:port pair of the shard, or false when all have been returned.
+func (p *Permutation) Next() (netip.AddrPort, bool) {
+	if p.inOrder {
+		return p.nextInOrder()
+	}
+	// the group has p-1 elements, of which those above the size are skipped
+	for p.pos < p.prime-1 {
+		e := p.cur
+		p.cur = mulMod(p.cur, p.step, p.prime)
+		p.pos += p.shards
+		if i := e - 1; i < p.size {
+			return p.at(i), true
+		}
+	}
+	return netip.AddrPort{}, false
+}
+
+// nextInOrder returns the next ip:port pair of the shard, in the order of the IPs then of the ports.
+func (p *Permutation) nextInOrder() (netip.AddrPort, bool) {
+	for p.r < len(p.ips) {
+		ap := netip.AddrPortFrom(p.ip, uint16(p.ports.At(p.port)))
+		if p.port++; p.port == p.ports.Len() {
+			p.port = 0
+			if p.ip == p.ips[p.r].Last {
+				if p.r++; p.r < len(p.ips) {
+					p.ip = p.ips[p.r].First
+				}
+			} else {
+				p.ip = p.ip.Next()
+			}
+		}
+		if p.skip > 0 {
+			p.skip--
+			continue
+		}
+		p.skip = p.shards - 1
+		return ap, true
+	}
+	return netip.AddrPort{}, false
+}
+
+// All returns the remaining ip:port pairs of the shard.
+func (p *Permutation) All() iter.Seq[netip.AddrPort] {
+	return func(yield func(netip.AddrPort) bool) {
+		for ap, ok := p.Next(); ok; ap, ok = p.Next() {
+			if !yield(ap) {
+				return
+			}
+		}
+	}
+}
+
+// at returns the i-th ip:port pair.
+func (p *Permutation) at(i uint64) netip.AddrPort {
+	numPorts := uint64(p.ports.Len())
+	ipIndex, portIndex := i/numPorts, i%numPorts
+	r := sort.Search(len(p.starts), func(r int) bool { return p.starts[r] > ipIndex }) - 1
+	ip := addAddr(p.ips[r].First, ipIndex-p.starts[r])
+	return netip.AddrPortFrom(ip, uint16(p.ports.At(int(portIndex))))
+}
+
+// addAddr returns the address n after ip.
+func addAddr(ip netip.Addr, n uint64) netip.Addr {
+	b := ip.As16()
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	lo, carry := bits.Add64(lo, n, 0)
+	hi += carry
+	binary.BigEndian.PutUint64(b[:8], hi)
+	binary.BigEndian.PutUint64(b[8:], lo)
+	sum := netip.AddrFrom16(b)
+	if ip.Is4() {
+		return sum.Unmap()
+	}
+	return sum.WithZone(ip.Zone())
+}
+
+// safePrimeAbove returns the smallest safe prime p = 2q+1, where q is prime, above n.
+// The primitive roots modulo a safe prime are easy to check.
+func safePrimeAbove(n uint64) uint64 {
+	// the group of p has p-1 elements, one per number from 0 to n-1 at least
+	for q := (n + 1) / 2; ; q++ {
+		// ProbablyPrime(0) is exact below 2^64
+		if new(big.Int).SetUint64(q).ProbablyPrime(0) && new(big.Int).SetUint64(2*q+1).ProbablyPrime(0) {
+			return 2*q + 1
+		}
+	}
+}
+
+// primitiveRoot returns a random primitive root modulo the safe prime p. As the group has
+// 2q elements, g is a generator if and only if its order is neither 2 nor q.
+func primitiveRoot(p uint64, rng *rand.Rand) uint64 {
+	q := (p - 1) / 2
+	for {
+		g := uint64(rng.Int63n(int64(p-3))) + 2
+		if mulMod(g, g, p) != 1 && powMod(g, q, p) != 1 {
+			return g
+		}
+	}
+}
+
+func mulMod(a, b, m uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	_, rem := bits.Div64(hi, lo, m)
+	return rem
+}
+
+func powMod(b, e, m uint64) uint64 {
+	r := uint64(1)
+	b %= m
+	for ; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			r = mulMod(r, b, m)
+		}
+		b = mulMod(b, b, m)
+	}
+	return r
+}
@@ -0,0 +1,182 @@
+package parseipportargs
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func TestPermutationShards(t *testing.T) {
+	tests := []struct {
+		name  string
+		ips   string
+		ports []int
+	}{
+		// sizes 1 and 2, the smallest groups
+		{"one pair", "192.0.2.1", []int{443}},
+		{"two ports", "192.0.2.1", []int{80, 443}},
+		{"two ips", "192.0.2.1-192.0.2.2", []int{443}},
+		// sizes around the safe primes 7 and 23
+		{"prime-1", "192.0.2.1-192.0.2.3", []int{80, 443}},
+		{"prime+1", "192.0.2.1,192.0.2.9,2001:db8::1-2001:db8::2", []int{80, 443}},
+		{"safe prime-1", "192.0.2.0/30,198.51.100.7-198.51.100.13,2001:db8::1-2001:db8::b", []int{53}},
+		{"safe prime+1", "192.0.2.0/30,2001:db8::/126", []int{22, 80, 443}},
+	}
+	for _, tt := range tests {
+		ips, err := ParseIPRanges(tt.ips)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		ports := NewPortSet(tt.ports...)
+		want := map[netip.AddrPort]bool{}
+		for ip := range ips.All() {
+			for _, port := range tt.ports {
+				want[netip.AddrPortFrom(ip, uint16(port))] = true
+			}
+		}
+		for _, n := range []int{1, 2, 3, 7, 30} {
+			seen := map[netip.AddrPort]int{}
+			for k := 0; k < n; k++ {
+				p, err := NewPermutation(ips, ports, 42, k, n)
+				if err != nil {
+					t.Fatalf("%v: shard %v/%v: %v", tt.name, k, n, err)
+				}
+				if p.Len() != uint64(len(want)) {
+					t.Errorf("%v: Len() = %v, want %v", tt.name, p.Len(), len(want))
+				}
+				for ap := range p.All() {
+					seen[ap]++
+				}
+			}
+			if len(seen) != len(want) {
+				t.Errorf("%v: %v shards visited %v pairs, want %v", tt.name, n, len(seen), len(want))
+			}
+			for ap, count := range seen {
+				if !want[ap] {
+					t.Errorf("%v: %v shards visited %v, which is not a target", tt.name, n, ap)
+				} else if count != 1 {
+					t.Errorf("%v: %v shards visited %v %v times", tt.name, n, ap, count)
+				}
+			}
+		}
+	}
+}
+
+func TestPermutationSeed(t *testing.T) {
+	ips, _ := ParseIPRanges("192.0.2.0/28")
+	ports := NewPortSet(80, 443)
+	order := func(seed int64) []netip.AddrPort {
+		p, err := NewPermutation(ips, ports, seed, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var aps []netip.AddrPort
+		for ap := range p.All() {
+			aps = append(aps, ap)
+		}
+		return aps
+	}
+	a, b := order(1), order(1)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("seed 1 gave %v then %v at %v", a[i], b[i], i)
+		}
+	}
+}
+
+func TestPermutationInvalid(t *testing.T) {
+	ips, _ := ParseIPRanges("192.0.2.1")
+	for _, shard := range [][2]int{{0, 0}, {-1, 2}, {2, 2}} {
+		if _, err := NewPermutation(ips, NewPortSet(443), 1, shard[0], shard[1]); err == nil {
+			t.Errorf("shard %v/%v: want an error", shard[0], shard[1])
+		}
+	}
+	if _, err := NewPermutation(&IPSet{}, NewPortSet(443), 1, 0, 1); err == nil {
+		t.Error("no ip: want an error")
+	}
+}
+
+func TestPermutationInOrder(t *testing.T) {
+	tests := []struct {
+		ips   string
+		ports []int
+		k, n  int
+		want  []string
+	}{
+		{"::/0", []int{443}, 0, 1, []string{"[::]:443", "[::1]:443", "[::2]:443"}},
+		{"::/0", []int{80, 443}, 1, 3, []string{"[::]:443", "[::2]:80", "[::3]:443", "[::5]:80"}},
+		// from a range to the next
+		{"10.0.0.0/31,8000::/1", []int{443}, 0, 1, []string{"10.0.0.0:443", "10.0.0.1:443", "[8000::]:443", "[8000::1]:443"}},
+		{"10.0.0.0/31,8000::/1", []int{80, 443}, 2, 3, []string{"10.0.0.1:80", "[8000::]:443", "[8000::2]:80"}},
+	}
+	for _, tt := range tests {
+		ips, err := ParseIPRanges(tt.ips)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewPermutation(ips, NewPortSet(tt.ports...), 1, tt.k, tt.n)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.ips, err)
+		}
+		if p.Len() != math.MaxUint64 {
+			t.Errorf("%v: Len() = %v, want %v", tt.ips, p.Len(), uint64(math.MaxUint64))
+		}
+		for i, want := range tt.want {
+			if ap, ok := p.Next(); !ok || ap.String() != want {
+				t.Errorf("%v shard %v/%v: pair %v = %v, want %v", tt.ips, tt.k, tt.n, i, ap, want)
+			}
+		}
+	}
+}
+
+func TestSafePrimeAbove(t *testing.T) {
+	tests := []struct {
+		n, want uint64
+	}{
+		{1, 5},
+		{2, 5},
+		{5, 7},
+		{6, 7},
+		{7, 11},
+		{22, 23},
+		{23, 47},
+		{24, 47},
+	}
+	for _, tt := range tests {
+		if got := safePrimeAbove(tt.n); got != tt.want {
+			t.Errorf("safePrimeAbove(%v) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPrimitiveRoot(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, p := range []uint64{5, 7, 11, 23, 47, 1019} {
+		for i := 0; i < 10; i++ {
+			g := primitiveRoot(p, rng)
+			// a generator visits all p-1 elements of the group before coming back to 1
+			seen := map[uint64]bool{}
+			for e, j := g, uint64(0); j < p-1; e, j = mulMod(e, g, p), j+1 {
+				seen[e] = true
+			}
+			if uint64(len(seen)) != p-1 {
+				t.Errorf("primitiveRoot(%v) = %v, which generates %v elements, want %v", p, g, len(seen), p-1)
+			}
+		}
+	}
+}
+
+func TestMulModPowMod(t *testing.T) {
+	// products above 2^64
+	a, b, m := uint64(1)<<63-25, uint64(1)<<62+7, uint64(1)<<62+135
+	want := new(big.Int).Mod(new(big.Int).Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b)), new(big.Int).SetUint64(m))
+	if got := mulMod(a, b, m); got != want.Uint64() {
+		t.Errorf("mulMod(%v, %v, %v) = %v, want %v", a, b, m, got, want)
+	}
+	want = new(big.Int).Exp(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b), new(big.Int).SetUint64(m))
+	if got := powMod(a, b, m); got != want.Uint64() {
+		t.Errorf("powMod(%v, %v, %v) = %v, want %v", a, b, m, got, want)
+	}
+}
@@ -0,0 +1,271 @@
+package parseipportargs
+
+import (
+	"bufio"
+	"cmp"
+	_ "embed"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	First, Last int
+}
+
+// Len returns the number of ports in the range.
+func (r PortRange) Len() int {
+	return r.Last - r.First + 1
+}
+
+func (r PortRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%v-%v", r.First, r.Last)
+}
+
+// PortSet is a set of ports, stored as sorted, disjoint ranges, so that it stays small
+// however many ports it has, eg. 10000-65000.
+type PortSet struct {
+	ranges []PortRange
+	starts []int // number of ports before every range
+}
+
+// NewPortSet returns the set of ports.
+func NewPortSet(ports ...int) *PortSet {
+	s := &PortSet{}
+	for _, p := range ports {
+		s.AddRange(p, p)
+	}
+	return s
+}
+
+// AddRange adds the ports from first to last to the set.
+func (s *PortSet) AddRange(first, last int) {
+	s.set(append(s.ranges, PortRange{first, last}))
+}
+
+// RemoveRange removes the ports from first to last from the set.
+func (s *PortSet) RemoveRange(first, last int) {
+	var ranges []PortRange
+	for _, r := range s.ranges {
+		if r.Last < first || r.First > last {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.First < first {
+			ranges = append(ranges, PortRange{r.First, first - 1})
+		}
+		if r.Last > last {
+			ranges = append(ranges, PortRange{last + 1, r.Last})
+		}
+	}
+	s.set(ranges)
+}
+
+// set sorts and merges ranges into the ranges of the set.
+func (s *PortSet) set(ranges []PortRange) {
+	slices.SortFunc(ranges, func(a, b PortRange) int { return a.First - b.First })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.First <= merged[n-1].Last+1 {
+			merged[n-1].Last = max(merged[n-1].Last, r.Last)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.ranges = merged
+	s.starts = make([]int, len(merged))
+	before := 0
+	for i, r := range merged {
+		s.starts[i] = before
+		before += r.Len()
+	}
+}
+
+func (s *PortSet) Ranges() []PortRange {
+	return s.ranges
+}
+
+// Len returns the number of ports in the set.
+func (s *PortSet) Len() int {
+	if s == nil || len(s.ranges) == 0 {
+		return 0
+	}
+	n := len(s.ranges) - 1
+	return s.starts[n] + s.ranges[n].Len()
+}
+
+func (s *PortSet) IsEmpty() bool {
+	return s.Len() == 0
+}
+
+// Contains reports whether port p is in the set.
+func (s *PortSet) Contains(p int) bool {
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].Last >= p })
+	return i < len(s.ranges) && s.ranges[i].First <= p
+}
+
+// At returns the i-th port of the set, in ascending order.
+func (s *PortSet) At(i int) int {
+	r := sort.Search(len(s.starts), func(r int) bool { return s.starts[r] > i }) - 1
+	return s.ranges[r].First + i - s.starts[r]
+}
+
+// All returns the ports of the set in ascending order.
+func (s *PortSet) All() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for _, r := range s.ranges {
+			for p := r.First; p <= r.Last; p++ {
+				if !yield(p) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Ports returns the ports of the set in ascending order.
+func (s *PortSet) Ports() []int {
+	return slices.Collect(s.All())
+}
+
+// String formats the set as a comma-separated list of ports and ranges, eg. 22,10000-29999,30101-65000.
+func (s *PortSet) String() string {
+	if s == nil {
+		return ""
+	}
+	terms := make([]string, len(s.ranges))
+	for i, r := range s.ranges {
+		terms[i] = r.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// portGroups are the named groups of ports of ParsePortSet, after the ranges of IANA.
+var portGroups = map[string]PortRange{
+	"all":        {1, 65535},
+	"well-known": {1, 1023},
+	"registered": {1024, 49151},
+	"ephemeral":  {49152, 65535},
+}
+
+// ParsePortSet parses a comma-separated list of ports, ranges and groups, eg. 443,10000-65000,!22,!30000-30100,top100.
+// A group is all, well-known (1-1023), registered (1024-49151), ephemeral (49152-65535),
+// or topN, the N most frequently open ports of proto in the bundled services table.
+// A term starting with ! is excluded, whatever its position; a list of exclusions only excludes from all.
+func ParsePortSet(s string, proto string) (*PortSet, error) {
+	set, excluded := &PortSet{}, &PortSet{}
+	for _, term := range strings.Split(s, ",") {
+		add := set
+		if t, ok := strings.CutPrefix(term, "!"); ok {
+			term, add = t, excluded
+		}
+		if err := addPortTerm(add.AddRange, term, proto); err != nil {
+			return nil, err
+		}
+	}
+	if set.IsEmpty() && !excluded.IsEmpty() {
+		set.AddRange(portGroups["all"].First, portGroups["all"].Last)
+	}
+	for _, r := range excluded.Ranges() {
+		set.RemoveRange(r.First, r.Last)
+	}
+	if set.IsEmpty() {
+		return nil, fmt.Errorf("no port left in %+q", s)
+	}
+	return set, nil
+}
+
+// addPortTerm calls add with the ports of a port, range or group, in the order of the group,
+// eg. the most frequently open first for topN.
+func addPortTerm(add func(first, last int), term, proto string) error {
+	if r, ok := portGroups[term]; ok {
+		add(r.First, r.Last)
+		return nil
+	}
+	if n, ok := strings.CutPrefix(term, "top"); ok {
+		top, err := strconv.Atoi(n)
+		if err != nil || top < 1 {
+			return fmt.Errorf("Invalid port group %+q: want topN, N > 0", term)
+		}
+		ports := topPorts(proto)
+		if top > len(ports) {
+			return fmt.Errorf("Invalid port group %+q: only %v %v ports are ranked", term, len(ports), proto)
+		}
+		for _, p := range ports[:top] {
+			add(p, p)
+		}
+		return nil
+	}
+	k := strings.Split(term, "-")
+	if len(k) == 1 {
+		p, err := aToPort(k[0])
+		if err != nil {
+			return err
+		}
+		add(p, p)
+	} else if len(k) == 2 {
+		low, err := aToPort(k[0])
+		if err != nil {
+			return err
+		}
+		high, err := aToPort(k[1])
+		if err != nil {
+			return err
+		}
+		if low > high {
+			return fmt.Errorf("port %v is higher than %v: %v", low, high, term)
+		}
+		add(low, high)
+	} else {
+		return fmt.Errorf("Invalid range syntax: %+q", term)
+	}
+	return nil
+}
+
+// servicesTable is in the format of nmap-services: service, port/proto and open frequency per line.
+//
+//go:embed services.txt
+var servicesTable string
+
+// topPorts returns the ports of proto in the services table, most frequently open first.
+func topPorts(proto string) []int {
+	type service struct {
+		port      int
+		frequency float64
+	}
+	var services []service
+	scanner := bufio.NewScanner(strings.NewReader(servicesTable))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		port, p, _ := strings.Cut(fields[1], "/")
+		if p != proto {
+			continue
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			panic("bad port in services.txt: " + line)
+		}
+		f, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			panic("bad frequency in services.txt: " + line)
+		}
+		services = append(services, service{n, f})
+	}
+	slices.SortStableFunc(services, func(a, b service) int { return cmp.Compare(b.frequency, a.frequency) })
+	ports := make([]int, len(services))
+	for i, s := range services {
+		ports[i] = s.port
+	}
+	return ports
+}
@@ -0,0 +1,38 @@
+package parseipportargs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePortArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []int
+	}{
+		{"443", []int{443}},
+		{"443,80", []int{443, 80}},
+		{"8443,80-82,81,443,80", []int{8443, 80, 81, 82, 443}},
+		// the most frequently open first
+		{"top3", []int{80, 23, 443}},
+		{"8080,top3,22", []int{8080, 80, 23, 443, 22}},
+		// exclusions, whatever their position
+		{"!23,443,top3,22", []int{443, 80, 22}},
+		{"!2-65535", []int{1}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortArgs(tt.s)
+		if err != nil {
+			t.Errorf("ParsePortArgs(%v): %v", tt.s, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParsePortArgs(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "x", "2-1", "1-2-3", "70000", "top0", "443,!443"} {
+		if got, err := ParsePortArgs(s); err == nil {
+			t.Errorf("ParsePortArgs(%v) = %v, want an error", s, got)
+		}
+	}
+}
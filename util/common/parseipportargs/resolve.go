@@ -0,0 +1,169 @@
+package parseipportargs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the hostnames found among IPs, eg. the name of a sink server.
+// Every name is resolved once, and its IPs are pinned for the rest of the run.
+// The zero value resolves both families with the system resolver.
+type Resolver struct {
+	// Server is the ip[:port] of the DNS server to resolve with, instead of the system resolver.
+	Server string
+	// HostsFile is a file in the format of /etc/hosts to resolve with, instead of DNS.
+	HostsFile string
+	// Network selects the families of the IPs: "ip" (both), "ip4" or "ip6". (default DefaultFamily)
+	Network string
+
+	mu sync.Mutex
+	// IPs of the names resolved so far
+	resolved map[string][]netip.Addr
+	hosts    map[string][]netip.Addr
+}
+
+// DefaultResolver resolves the hostnames parsed by ParseIPArgs and ParseIPSet.
+// RegisterFlags binds it to command-line flags.
+var DefaultResolver Resolver
+
+const resolveTimeout = 10 * time.Second
+
+// isHostname reports whether s looks like a hostname rather than a malformed IP.
+func isHostname(s string) bool {
+	if s == "" || len(s) > maxHostnameLength || strings.HasPrefix(s, "-") {
+		return false
+	}
+	letter := false
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			letter = true
+		case '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_':
+		default:
+			return false
+		}
+	}
+	return letter
+}
+
+const maxHostnameLength = 253
+
+// Resolve returns the IPs of name, resolving it on the first call only.
+func (r *Resolver) Resolve(name string) ([]netip.Addr, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ips, ok := r.resolved[name]; ok {
+		return ips, nil
+	}
+	ips, err := r.lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %+q: %w", name, err)
+	}
+	ips = slices.DeleteFunc(ips, func(ip netip.Addr) bool {
+		network := r.network()
+		return (network == "ip4" && !ip.Is4()) || (network == "ip6" && !ip.Is6())
+	})
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no %v address for %+q", r.network(), name)
+	}
+	if r.resolved == nil {
+		r.resolved = make(map[string][]netip.Addr)
+	}
+	r.resolved[name] = ips
+	log.Println("resolved", name, "to", ips)
+	return ips, nil
+}
+
+// network returns Network, or the network of DefaultFamily if it is empty.
+func (r *Resolver) network() string {
+	if r.Network != "" {
+		return r.Network
+	}
+	switch DefaultFamily {
+	case IPv4:
+		return "ip4"
+	case IPv6:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+func (r *Resolver) lookup(name string) ([]netip.Addr, error) {
+	if r.HostsFile != "" {
+		if r.hosts == nil {
+			hosts, err := readHosts(r.HostsFile)
+			if err != nil {
+				return nil, err
+			}
+			r.hosts = hosts
+		}
+		return slices.Clone(r.hosts[name]), nil
+	}
+	resolver := net.DefaultResolver
+	if r.Server != "" {
+		server := r.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := resolver.LookupNetIP(ctx, r.network(), name)
+	for i, ip := range ips {
+		ips[i] = ip.Unmap()
+	}
+	return ips, err
+}
+
+// readHosts reads a file in the format of /etc/hosts: an IP followed by its names, with # comments.
+func readHosts(path string) (map[string][]netip.Addr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hosts := make(map[string][]netip.Addr)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", path, line, err)
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			hosts[name] = append(hosts[name], ip)
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// Resolutions returns the IPs of the names resolved so far, to record them in the metadata of a run.
+func (r *Resolver) Resolutions() map[string][]netip.Addr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.resolved)
+}
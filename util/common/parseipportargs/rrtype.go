@@ -0,0 +1,307 @@
+package parseipportargs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RRType is a DNS resource record type. It formats as its name, or as TYPEnnn
+// after RFC 3597 when it has none.
+type RRType uint16
+
+// RRClass is a DNS class. It formats as its name, or as CLASSnnn when it has none.
+type RRClass uint16
+
+const (
+	ClassIN   RRClass = 1
+	ClassCH   RRClass = 3
+	ClassHS   RRClass = 4
+	ClassNONE RRClass = 254
+	ClassANY  RRClass = 255
+)
+
+// RRFlags tell the types and classes which are not for data.
+type RRFlags uint8
+
+const (
+	// Meta types and classes only appear in messages, eg. OPT, never in zones (RFC 6895).
+	Meta RRFlags = 1 << iota
+	// QueryOnly types and classes only appear in questions, eg. AXFR or ANY.
+	QueryOnly
+)
+
+type rrEntry struct {
+	value uint16
+	name  string
+	flags RRFlags
+}
+
+// https://tools.ietf.org/html/rfc1035#section-3.2.2
+// https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-4
+// https://github.com/miekg/dns/blob/master/types.go#L24
+var rrTypes = []rrEntry{
+	{0, "None", 0},
+	{1, "A", 0},
+	{2, "NS", 0},
+	{3, "MD", 0},
+	{4, "MF", 0},
+	{5, "CNAME", 0},
+	{6, "SOA", 0},
+	{7, "MB", 0},
+	{8, "MG", 0},
+	{9, "MR", 0},
+	{10, "NULL", 0},
+	{12, "PTR", 0},
+	{13, "HINFO", 0},
+	{14, "MINFO", 0},
+	{15, "MX", 0},
+	{16, "TXT", 0},
+	{17, "RP", 0},
+	{18, "AFSDB", 0},
+	{19, "X25", 0},
+	{20, "ISDN", 0},
+	{21, "RT", 0},
+	{23, "NSAPPTR", 0},
+	{24, "SIG", 0},
+	{25, "KEY", 0},
+	{26, "PX", 0},
+	{27, "GPOS", 0},
+	{28, "AAAA", 0},
+	{29, "LOC", 0},
+	{30, "NXT", 0},
+	{31, "EID", 0},
+	{32, "NIMLOC", 0},
+	{33, "SRV", 0},
+	{34, "ATMA", 0},
+	{35, "NAPTR", 0},
+	{36, "KX", 0},
+	{37, "CERT", 0},
+	{39, "DNAME", 0},
+	{41, "OPT", Meta},
+	{42, "APL", 0},
+	{43, "DS", 0},
+	{44, "SSHFP", 0},
+	{46, "RRSIG", 0},
+	{47, "NSEC", 0},
+	{48, "DNSKEY", 0},
+	{49, "DHCID", 0},
+	{50, "NSEC3", 0},
+	{51, "NSEC3PARAM", 0},
+	{52, "TLSA", 0},
+	{53, "SMIMEA", 0},
+	{55, "HIP", 0},
+	{56, "NINFO", 0},
+	{57, "RKEY", 0},
+	{58, "TALINK", 0},
+	{59, "CDS", 0},
+	{60, "CDNSKEY", 0},
+	{61, "OPENPGPKEY", 0},
+	{62, "CSYNC", 0},
+	{63, "ZONEMD", 0},
+	{64, "SVCB", 0},
+	{65, "HTTPS", 0},
+	{99, "SPF", 0},
+	{100, "UINFO", 0},
+	{101, "UID", 0},
+	{102, "GID", 0},
+	{103, "UNSPEC", 0},
+	{104, "NID", 0},
+	{105, "L32", 0},
+	{106, "L64", 0},
+	{107, "LP", 0},
+	{108, "EUI48", 0},
+	{109, "EUI64", 0},
+	{256, "URI", 0},
+	{257, "CAA", 0},
+	{258, "AVC", 0},
+
+	{249, "TKEY", Meta},
+	{250, "TSIG", Meta},
+
+	// valid Question.Qtype only
+	{251, "IXFR", QueryOnly},
+	{252, "AXFR", QueryOnly},
+	{253, "MAILB", QueryOnly},
+	{254, "MAILA", QueryOnly},
+	{255, "ANY", QueryOnly},
+
+	{32768, "TA", 0},
+	{32769, "DLV", 0},
+	{65535, "Reserved", 0},
+}
+
+// https://tools.ietf.org/html/rfc1035#section-3.2.4
+var rrClasses = []rrEntry{
+	{uint16(ClassIN), "IN", 0},
+	{2, "CS", 0},
+	{uint16(ClassCH), "CH", 0},
+	{uint16(ClassHS), "HS", 0},
+	// valid in updates only
+	{uint16(ClassNONE), "NONE", Meta},
+	// valid Question.Qclass only
+	{uint16(ClassANY), "ANY", QueryOnly},
+}
+
+// rrRegistry looks up the entries of a table by value and by case-insensitive name.
+type rrRegistry struct {
+	kind string
+	// prefix of the generic names of RFC 3597, TYPE or CLASS
+	generic string
+	byValue map[uint16]rrEntry
+	byName  map[string]rrEntry
+}
+
+func newRegistry(kind, generic string, entries []rrEntry) *rrRegistry {
+	r := &rrRegistry{kind: kind, generic: generic, byValue: map[uint16]rrEntry{}, byName: map[string]rrEntry{}}
+	for _, e := range entries {
+		r.byValue[e.value] = e
+		r.byName[strings.ToUpper(e.name)] = e
+	}
+	return r
+}
+
+var (
+	typeRegistry  = newRegistry("RRType", "TYPE", rrTypes)
+	classRegistry = newRegistry("RRClass", "CLASS", rrClasses)
+)
+
+func (r *rrRegistry) name(v uint16) string {
+	if e, ok := r.byValue[v]; ok {
+		return e.name
+	}
+	return r.generic + strconv.Itoa(int(v))
+}
+
+// parse parses a name, a number, or the generic TYPEnnn or CLASSnnn.
+func (r *rrRegistry) parse(s string) (uint16, error) {
+	if e, ok := r.byName[strings.ToUpper(s)]; ok {
+		return e.value, nil
+	}
+	n := s
+	if len(s) > len(r.generic) && strings.EqualFold(s[:len(r.generic)], r.generic) {
+		n = s[len(r.generic):]
+	}
+	v, err := strconv.Atoi(n)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %v: %v", r.kind, s)
+	}
+	if v < 0 || v > 65535 {
+		return 0, fmt.Errorf("%v out of range 0-65535: %v", r.kind, v)
+	}
+	return uint16(v), nil
+}
+
+// MapRRType maps the names of the RR types to their values.
+var MapRRType = func() map[string]uint16 {
+	m := make(map[string]uint16, len(rrTypes))
+	for _, e := range rrTypes {
+		m[e.name] = e.value
+	}
+	return m
+}()
+
+func (t RRType) String() string {
+	return typeRegistry.name(uint16(t))
+}
+
+// Flags returns whether t is a meta or a query-only type.
+func (t RRType) Flags() RRFlags {
+	return typeRegistry.byValue[uint16(t)].flags
+}
+
+// ParseRRType parses the name of a type, case-insensitively, eg. AAAA, its value, eg. 28,
+// or its generic name, eg. TYPE28 or TYPE65534.
+func ParseRRType(s string) (RRType, error) {
+	v, err := typeRegistry.parse(s)
+	return RRType(v), err
+}
+
+func (c RRClass) String() string {
+	return classRegistry.name(uint16(c))
+}
+
+// Flags returns whether c is a meta or a query-only class.
+func (c RRClass) Flags() RRFlags {
+	return classRegistry.byValue[uint16(c)].flags
+}
+
+// ParseRRClass parses the name of a class, case-insensitively, eg. CH, its value, eg. 3,
+// or its generic name, eg. CLASS3.
+func ParseRRClass(s string) (RRClass, error) {
+	v, err := classRegistry.parse(s)
+	return RRClass(v), err
+}
+
+// Set parses s as ParseRRClass does, so that *RRClass is a flag.Value.
+func (c *RRClass) Set(s string) error {
+	v, err := ParseRRClass(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+func ValidateRRTypeRange(p int) error {
+	if p < 0 || p > 65535 {
+		return fmt.Errorf("RRType out of range 0-65535: %v", p)
+	}
+	return nil
+}
+
+func aToRRType(RRType string) (uint16, error) {
+	t, err := ParseRRType(RRType)
+	return uint16(t), err
+}
+
+func uniqRRType(RRTypes []uint16) []uint16 {
+	set := make(map[uint16]bool)
+	uniqRRTypes := make([]uint16, 0)
+	for _, p := range RRTypes {
+		if set[p] {
+			continue
+		}
+		set[p] = true
+		uniqRRTypes = append(uniqRRTypes, p)
+	}
+	return uniqRRTypes
+}
+
+// ParseRRTypeArgs parses a comma-separated list of types and ranges of types, eg. A,AAAA,16-18,TYPE65534,
+// where a type is parsed by ParseRRType.
+func ParseRRTypeArgs(s string) ([]uint16, error) {
+	RRTypes := make([]uint16, 0)
+	for _, b := range strings.Split(s, ",") {
+		k := strings.Split(b, "-")
+		if len(k) == 1 {
+			p, err := aToRRType(k[0])
+			if err != nil {
+				return nil, err
+			}
+			RRTypes = append(RRTypes, p)
+		} else if len(k) == 2 {
+			low, err := aToRRType(k[0])
+			if err != nil {
+				return nil, err
+			}
+			high, err := aToRRType(k[1])
+			if err != nil {
+				return nil, err
+			}
+			if low > high {
+				return nil, fmt.Errorf("RRType %v is higher than %v: %v", low, high, b)
+			}
+			// important to cast to unit32 to avoid overflow when 65535++.
+			for p := uint32(low); p <= uint32(high); p++ {
+				RRTypes = append(RRTypes, uint16(p))
+			}
+		} else {
+			return nil, fmt.Errorf("Invalid range syntax: %+q", b)
+		}
+	}
+	// remove duplicates
+	uniqRRTypes := uniqRRType(RRTypes)
+
+	return uniqRRTypes, nil
+}
@@ -0,0 +1,179 @@
+package parseipportargs
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"net/netip"
+	"strings"
+	"unicode"
+)
+
+// Protocols of targets.
+const (
+	UDP = "udp"
+	TCP = "tcp"
+)
+
+// Target is a set of IPs to probe on a list of ports with a protocol.
+type Target struct {
+	IPs   *IPSet
+	Ports *PortSet
+	// Proto is UDP or TCP.
+	Proto string
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("[%v]:%v/%v", t.IPs, t.Ports, t.Proto)
+}
+
+// ParseTargets parses targets separated by spaces or semicolons, eg. "1.1.1.1:53/udp [2001:db8::1]:853/tcp",
+// as ParseTarget does.
+func ParseTargets(s string, defaultPorts *PortSet, defaultProto string) ([]Target, error) {
+	terms := strings.FieldsFunc(s, func(r rune) bool { return r == ';' || unicode.IsSpace(r) })
+	if len(terms) == 0 {
+		return nil, errors.New("no target")
+	}
+	targets := make([]Target, 0, len(terms))
+	for _, term := range terms {
+		t, err := ParseTarget(term, defaultPorts, defaultProto)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// ParseTarget parses a target of the form ips[:ports][/proto], eg. 1.1.1.1:53/udp,
+// 1.1.1.0/24,2.2.2.2:53,5353 or [2001:db8::1]:853/tcp, where ips is parsed by ParseIPSet,
+// ports by ParsePortSet, and proto is udp or tcp. IPv6 addresses must be bracketed when
+// followed by ports. The ports and protocol default to defaultPorts and defaultProto.
+func ParseTarget(s string, defaultPorts *PortSet, defaultProto string) (Target, error) {
+	t, host, err := splitTarget(s, defaultPorts, defaultProto)
+	if err != nil {
+		return Target{}, err
+	}
+	if t.Ports.IsEmpty() {
+		return Target{}, fmt.Errorf("invalid target %+q: no port", s)
+	}
+	if t.Proto != UDP && t.Proto != TCP {
+		return Target{}, fmt.Errorf("invalid target %+q: protocol must be udp or tcp", s)
+	}
+	t.IPs, err = ParseIPSet(host)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid target %+q: %w", s, err)
+	}
+	return t, nil
+}
+
+// ValidateTargets checks the syntax of targets as ParseTargets parses them, without resolving
+// hostnames, selecting IPs nor reading files, eg. to check a configuration early.
+func ValidateTargets(s string) error {
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || unicode.IsSpace(r) }) {
+		_, host, err := splitTarget(term, nil, "")
+		if err != nil {
+			return err
+		}
+		for _, ip := range strings.Split(host, ",") {
+			if err := validateIPTerm(ip); err != nil {
+				return fmt.Errorf("invalid target %+q: %w", term, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateIPTerm checks the syntax of a term of parseIPTerms.
+func validateIPTerm(term string) error {
+	if pattern, ok := strings.CutPrefix(term, "@"); ok {
+		if pattern == "" {
+			return errors.New("missing file after @")
+		}
+		return nil
+	}
+	if isSelector(term) {
+		_, err := parseSelector(term)
+		return err
+	}
+	_, err := ParseIPRange(term)
+	if err != nil && isHostname(term) {
+		return nil
+	}
+	return err
+}
+
+// splitTarget parses the ports and the protocol of a target, and returns its ips.
+func splitTarget(s string, defaultPorts *PortSet, defaultProto string) (Target, string, error) {
+	t := Target{Ports: defaultPorts, Proto: defaultProto}
+	host := s
+	// the protocol, not to be confused with the length of a CIDR
+	if i := strings.LastIndex(host, "/"); i >= 0 {
+		if proto := strings.ToLower(host[i+1:]); proto == UDP || proto == TCP {
+			t.Proto = proto
+			host = host[:i]
+		}
+	}
+	var ports string
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return Target{}, "", fmt.Errorf("invalid target %+q: missing ]", s)
+		}
+		rest := host[end+1:]
+		host = host[1:end]
+		if rest != "" {
+			var found bool
+			ports, found = strings.CutPrefix(rest, ":")
+			if !found {
+				return Target{}, "", fmt.Errorf("invalid target %+q: want [ip]:ports", s)
+			}
+		}
+	} else if strings.Count(host, ":")-countSelectors(host) == 1 {
+		// more colons are an IPv6 address without port
+		i := strings.LastIndex(host, ":")
+		host, ports = host[:i], host[i+1:]
+	}
+	if ports != "" {
+		var err error
+		t.Ports, err = ParsePortSet(ports, t.Proto)
+		if err != nil {
+			return Target{}, "", fmt.Errorf("invalid target %+q: %w", s, err)
+		}
+	}
+	return t, host, nil
+}
+
+// countSelectors returns the number of asn: and cc: selectors in the comma-separated list s,
+// to tell their colon from the one before the ports.
+func countSelectors(s string) int {
+	n := 0
+	for _, term := range strings.Split(s, ",") {
+		if isSelector(term) {
+			n++
+		}
+	}
+	return n
+}
+
+// Endpoint is an ip:port to probe with a protocol.
+type Endpoint struct {
+	netip.AddrPort
+	Proto string
+}
+
+// Endpoints returns the endpoints of targets, target after target, and for every target port
+// after port then ip, to send to different servers evenly. It takes constant memory.
+func Endpoints(targets []Target) iter.Seq[Endpoint] {
+	return func(yield func(Endpoint) bool) {
+		for _, t := range targets {
+			for port := range t.Ports.All() {
+				for ip := range t.IPs.All() {
+					if !yield(Endpoint{netip.AddrPortFrom(ip, uint16(port)), t.Proto}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package parseipportargs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"common/readfiles"
+)
+
+// ParseIPRange parses an IP, a CIDR, eg. 1.1.1.0/24, or an inclusive range of IPs of the same family, eg. 1.1.1.1-1.1.1.9.
+// IPv6 addresses may have a zone, eg. fe80::1%eth0, fe80::%eth0/64 or fe80::1%eth0-fe80::9%eth0.
+func ParseIPRange(s string) (IPRange, error) {
+	if strings.Contains(s, "/") {
+		// netip does not parse prefixes with a zone
+		cidr, zone := s, ""
+		if i, j := strings.Index(s, "%"), strings.Index(s, "/"); i >= 0 && i < j {
+			cidr, zone = s[:i]+s[j:], s[i+1:j]
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil && zone != "" && !prefix.Addr().Is6() {
+			err = errors.New("zone on an IPv4 prefix")
+		}
+		if err != nil {
+			return IPRange{}, fmt.Errorf("Error happened when parsing CIDR %+q: %s", s, err)
+		}
+		return PrefixRange(prefix).withZone(zone), nil
+	}
+	// zones may have dashes, eg. fe80::1%br-lan, so s is a range only if it is not an IP,
+	// or if its zone holds another address, eg. fe80::1%eth0-fe80::9%eth0
+	ip, err := netip.ParseAddr(s)
+	if err == nil && !strings.ContainsAny(ip.Zone(), ":%") {
+		return IPRange{First: ip, Last: ip}, nil
+	}
+	// a range is split at the first dash with an IP on both sides
+	var rangeErr error
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			continue
+		}
+		from, err := netip.ParseAddr(s[:i])
+		if err == nil {
+			var to netip.Addr
+			if to, err = netip.ParseAddr(s[i+1:]); err == nil {
+				r, err := NewIPRange(from, to)
+				if err != nil {
+					return IPRange{}, fmt.Errorf("Invalid range %+q: %s", s, err)
+				}
+				return r, nil
+			}
+		}
+		if rangeErr == nil {
+			rangeErr = err
+		}
+	}
+	if rangeErr != nil {
+		return IPRange{}, fmt.Errorf("Invalid range %+q: %s", s, rangeErr)
+	}
+	if err == nil {
+		return IPRange{First: ip, Last: ip}, nil
+	}
+	return IPRange{}, fmt.Errorf("Invalid IP %+q: %s", s, err)
+}
+
+// parseIPTerms calls add with the range of every term in the comma-separated list s.
+// A term is parsed by ParseIPRange, selected by DefaultIPDB if it is asn:N or cc:XX,
+// or read by ReadIPFile if it starts with @.
+func parseIPTerms(s string, add func(IPRange)) error {
+	for _, term := range strings.Split(s, ",") {
+		if pattern, ok := strings.CutPrefix(term, "@"); ok {
+			if err := ReadIPFile(pattern, add); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := parseIPTerm(term, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIPTerm calls add with the range parsed by ParseIPRange, with the ranges of an asn: or cc: selector,
+// or with the IPs of a hostname, resolved by DefaultResolver.
+func parseIPTerm(term string, add func(IPRange)) error {
+	if isSelector(term) {
+		set, err := DefaultIPDB.Select(term)
+		if err != nil {
+			return err
+		}
+		for _, r := range set.Ranges() {
+			add(r)
+		}
+		return nil
+	}
+	r, err := ParseIPRange(term)
+	if err == nil {
+		add(r)
+		return nil
+	}
+	if !isHostname(term) {
+		return err
+	}
+	ips, err := DefaultResolver.Resolve(term)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		add(IPRange{First: ip, Last: ip})
+	}
+	return nil
+}
+
+// ReadIPFile calls add with the range of every IP, CIDR, range, selector and hostname listed in the files matching pattern,
+// one per line, with # comments and blank lines. The files are read with readfiles, so pattern may be
+// a glob, a directory, or - for standard input, and compressed files are decompressed transparently.
+// It is an error if the files list nothing.
+func ReadIPFile(pattern string, add func(IPRange)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	// stop reading on error
+	defer cancel()
+	records, errs := readfiles.ReadRecords(ctx, []string{pattern}, readfiles.Options{})
+	n := 0
+	for rec := range records {
+		entry, _, _ := strings.Cut(rec.Text, "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := parseIPTerm(entry, add); err != nil {
+			return fmt.Errorf("%v:%v: %w", rec.Source, rec.Line, err)
+		}
+		n++
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no IP in @%v", pattern)
+	}
+	return nil
+}
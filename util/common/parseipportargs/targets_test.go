@@ -0,0 +1,37 @@
+package parseipportargs
+
+import "testing"
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"1.1.1.1", "1.1.1.1"},
+		{"1.1.1.0/24", "1.1.1.0/24"},
+		{"1.1.1.1-1.1.1.9", "1.1.1.1-1.1.1.9"},
+		{"2001:db8::1-2001:db8::9", "2001:db8::1-2001:db8::9"},
+		{"fe80::1%eth0", "fe80::1%eth0"},
+		{"fe80::%eth0/64", "fe80::%eth0/64"},
+		{"fe80::1%eth0-fe80::9%eth0", "fe80::1%eth0-fe80::9%eth0"},
+		// zones with dashes, eg. of docker bridges
+		{"fe80::1%br-lan", "fe80::1%br-lan"},
+		{"fe80::%br-lan/64", "fe80::%br-lan/64"},
+		{"fe80::1%br-lan-fe80::9%br-lan", "fe80::1%br-lan-fe80::9%br-lan"},
+		{"fe80::1%br-0a1b-fe80::9%br-0a1b", "fe80::1%br-0a1b-fe80::9%br-0a1b"},
+	}
+	for _, tt := range tests {
+		r, err := ParseIPRange(tt.s)
+		if err != nil {
+			t.Errorf("ParseIPRange(%v): %v", tt.s, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("ParseIPRange(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "x", "1.1.1.9-1.1.1.1", "1.1.1.1-x", "1.1.1.1-::1", "fe80::1%eth0-fe80::9%eth1", "1.1.1.0/33"} {
+		if r, err := ParseIPRange(s); err == nil {
+			t.Errorf("ParseIPRange(%v) = %v, want an error", s, r)
+		}
+	}
+}
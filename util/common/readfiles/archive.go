@@ -0,0 +1,111 @@
+package readfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// archiveSuffixes are the suffixes of the names of the archives whose members are read as separate inputs.
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.xz", ".txz", ".tar.bz2", ".tbz2", ".zip"}
+
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberSource is a member of an archive, named "archive:member". It can only
+// be opened while its archive is being read.
+type memberSource struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func (s memberSource) Name() string { return s.name }
+
+func (s memberSource) Open() (io.ReadCloser, error) { return s.open() }
+
+// readArchive reads the regular files in the tar or zip archive source, which pass the Include
+// and Exclude patterns, as separate inputs. The members are decompressed like any other input.
+func readArchive(ctx context.Context, source Source, opts Options, emit func(Record) bool) error {
+	file, err := source.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.HasSuffix(strings.ToLower(source.Name()), ".zip") {
+		return readZip(ctx, source.Name(), file, opts, emit)
+	}
+	// compressed tarballs
+	r, err := NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%v: %w", source.Name(), err)
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", source.Name(), err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || !keepFile(opts, hdr.Name) {
+			continue
+		}
+		member := memberSource{
+			name: source.Name() + ":" + hdr.Name,
+			open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := readMember(ctx, member, opts, emit); err != nil {
+			return err
+		}
+	}
+}
+
+func readZip(ctx context.Context, name string, file io.ReadCloser, opts Options, emit func(Record) bool) error {
+	f, ok := file.(*os.File)
+	if !ok {
+		return fmt.Errorf("%v: %w", name, errors.New("zip archives must be files"))
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("%v: %w", name, err)
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() || !keepFile(opts, zf.Name) {
+			continue
+		}
+		member := memberSource{name: name + ":" + zf.Name, open: zf.Open}
+		if err := readMember(ctx, member, opts, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMember reads a member of an archive, skipping it on error if opts.SkipErrors is set.
+func readMember(ctx context.Context, member Source, opts Options, emit func(Record) bool) error {
+	err := readSource(ctx, member, opts, emit)
+	if err != nil && opts.SkipErrors && ctx.Err() == nil {
+		log.Println("skipping input", member.Name(), err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package readfiles
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Checkpoint persists the progress of a long run over its inputs, so that a
+// restarted run skips the records which are already done and reprocesses only
+// the unfinished ones.
+//
+// A record is started when it is handed to a worker, and done when its result
+// is safely written. Per input, the checkpoint keeps the offset after the last
+// started record and the set of records in flight, i.e. started but not done.
+// Records must therefore be started in input order, see CheckOrder.
+//
+// All methods are safe for concurrent use, and are no-ops on a nil *Checkpoint,
+// so that callers do not need to check whether checkpointing is enabled.
+type Checkpoint struct {
+	path     string
+	interval time.Duration
+
+	mu       sync.Mutex
+	inputs   map[string]*progress
+	resumed  map[string]*progress
+	lastSave time.Time
+
+	// serializes saves, so that an older snapshot never replaces a newer one
+	saveMu sync.Mutex
+}
+
+// progress is the progress over one input.
+type progress struct {
+	// offset after the last started record
+	offset int64
+	// offsets of the records in flight
+	inFlight map[int64]bool
+}
+
+// checkpointFile is the on-disk format of a checkpoint.
+type checkpointFile struct {
+	Inputs map[string]progressFile `json:"inputs"`
+}
+
+type progressFile struct {
+	Offset   int64   `json:"offset"`
+	InFlight []int64 `json:"in_flight,omitempty"`
+}
+
+// NewCheckpoint returns a checkpoint which is saved to path at most once per interval.
+// If resume is true, the progress saved in path by a previous run is loaded,
+// and Skip reports the records which that run has done. A missing file is
+// treated as no progress.
+func NewCheckpoint(path string, interval time.Duration, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path:     path,
+		interval: interval,
+		inputs:   make(map[string]*progress),
+		resumed:  make(map[string]*progress),
+		lastSave: time.Now(),
+	}
+	if !resume {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f checkpointFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, &os.PathError{Op: "parse", Path: path, Err: err}
+	}
+	for name, pf := range f.Inputs {
+		// the records in flight stay in flight until they are done in this run
+		c.resumed[name] = newProgress(pf)
+		c.inputs[name] = newProgress(pf)
+	}
+	return c, nil
+}
+
+// CheckOrder returns an error if opts reorder the records, ie. with Shuffle or with a Sample
+// of N records. The records held back at a crash would be before the offset of the checkpoint
+// without being in flight, so a resumed run would skip them.
+func CheckOrder(opts Options) error {
+	if opts.Shuffle > 0 {
+		return errors.New("cannot checkpoint shuffled records, which are not read in input order")
+	}
+	if opts.Sample.N > 0 {
+		return errors.New("cannot checkpoint a sample of a number of records, which are not read in input order")
+	}
+	return nil
+}
+
+func newProgress(pf progressFile) *progress {
+	p := &progress{offset: pf.Offset, inFlight: make(map[int64]bool)}
+	for _, off := range pf.InFlight {
+		p.inFlight[off] = true
+	}
+	return p
+}
+
+// Skip reports whether r has been done by the resumed run.
+func (c *Checkpoint) Skip(r Record) bool {
+	if c == nil {
+		return false
+	}
+	// resumed is never modified after NewCheckpoint
+	p, ok := c.resumed[r.Source]
+	return ok && r.Offset < p.offset && !p.inFlight[r.Offset]
+}
+
+// Start marks r as in flight.
+func (c *Checkpoint) Start(r Record) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.inputs[r.Source]
+	if !ok {
+		p = &progress{inFlight: make(map[int64]bool)}
+		c.inputs[r.Source] = p
+	}
+	p.inFlight[r.Offset] = true
+	if end := r.Offset + r.Size; end > p.offset {
+		p.offset = end
+	}
+}
+
+// Done marks r as done.
+func (c *Checkpoint) Done(r Record) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.inputs[r.Source]; ok {
+		delete(p.inFlight, r.Offset)
+	}
+}
+
+// Due reports whether the interval has passed since the last save.
+func (c *Checkpoint) Due() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastSave) >= c.interval
+}
+
+// Save atomically writes the checkpoint to its file.
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	f := checkpointFile{Inputs: make(map[string]progressFile, len(c.inputs))}
+	for name, p := range c.inputs {
+		pf := progressFile{Offset: p.offset}
+		for off := range p.inFlight {
+			pf.InFlight = append(pf.InFlight, off)
+		}
+		sort.Slice(pf.InFlight, func(i, j int) bool { return pf.InFlight[i] < pf.InFlight[j] })
+		f.Inputs[name] = pf
+	}
+	c.lastSave = time.Now()
+	c.mu.Unlock()
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that a crash while saving does
+	// not destroy the previous checkpoint.
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,83 @@
+package readfiles
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// magic numbers of the supported compression formats.
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicBzip2 = []byte{'B', 'Z', 'h'}
+)
+
+// decompressor wraps a decompressing reader and releases its resources on Close.
+// It does not close the underlying reader.
+type decompressor struct {
+	io.Reader
+	close func() error
+}
+
+func (d *decompressor) Close() error {
+	if d.close == nil {
+		return nil
+	}
+	return d.close()
+}
+
+// NewReader sniffs the magic bytes at the beginning of r and returns a reader
+// that transparently decompresses gzip, zstd, xz and bzip2 streams.
+// Any other input is returned as it is.
+// Closing the returned reader does not close r.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// Peek returns a short slice and an error when the input is shorter
+	// than the longest magic number. That is fine, as such an input
+	// cannot be compressed anyway.
+	head, _ := br.Peek(len(magicXz))
+
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		// concatenated gzip members, as produced by `cat a.gz b.gz`, are read as one stream.
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressor{Reader: zr, close: zr.Close}, nil
+	case bytes.HasPrefix(head, magicZstd):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressor{Reader: zr, close: func() error { zr.Close(); return nil }}, nil
+	case bytes.HasPrefix(head, magicXz):
+		zr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressor{Reader: zr}, nil
+	case isBzip2(head):
+		return &decompressor{Reader: bzip2.NewReader(br)}, nil
+	default:
+		return &decompressor{Reader: br}, nil
+	}
+}
+
+// isBzip2 tells whether head starts a bzip2 stream. The byte after "BZh" is the block size,
+// which avoids mistaking a plain text line starting with "BZh" for a bzip2 stream.
+func isBzip2(head []byte) bool {
+	return bytes.HasPrefix(head, magicBzip2) && len(head) > len(magicBzip2) && '1' <= head[3] && head[3] <= '9'
+}
+
+// isCompressed tells whether head, the beginning of an input, is a stream NewReader decompresses.
+func isCompressed(head []byte) bool {
+	return bytes.HasPrefix(head, magicGzip) || bytes.HasPrefix(head, magicZstd) || bytes.HasPrefix(head, magicXz) || isBzip2(head)
+}
@@ -0,0 +1,127 @@
+package readfiles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// DedupMode decides how duplicate records are dropped.
+type DedupMode int
+
+const (
+	// DedupOff keeps duplicates.
+	DedupOff DedupMode = iota
+	// DedupExact remembers every distinct record in a hash set.
+	DedupExact
+	// DedupApprox remembers records in a Bloom filter of bounded memory,
+	// which drops a small fraction of distinct records as false positives.
+	DedupApprox
+)
+
+var dedupNames = []string{"off", "exact", "approx"}
+
+func (m *DedupMode) String() string {
+	if m == nil || int(*m) >= len(dedupNames) {
+		return dedupNames[0]
+	}
+	return dedupNames[*m]
+}
+
+func (m *DedupMode) Set(s string) error {
+	for i, name := range dedupNames {
+		if s == name {
+			*m = DedupMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid dedup mode %+q: must be one of %v", s, strings.Join(dedupNames, ", "))
+}
+
+const (
+	defaultDedupFPRate   = 0.001
+	defaultDedupCapacity = 10000000
+)
+
+// NormalizeName lower-cases s and removes surrounding whitespace and trailing dots,
+// so that "WWW.Example.com. " and "www.example.com" compare equal.
+func NormalizeName(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, ".")
+	return strings.ToLower(s)
+}
+
+// deduper reports whether a record has been seen before, and remembers it.
+type deduper interface {
+	seen(s string) bool
+}
+
+func newDeduper(opts Options) deduper {
+	switch opts.Dedup {
+	case DedupExact:
+		return exactSet{}
+	case DedupApprox:
+		p := opts.DedupFPRate
+		if p <= 0 || p >= 1 {
+			p = defaultDedupFPRate
+		}
+		n := opts.DedupCapacity
+		if n <= 0 {
+			n = defaultDedupCapacity
+		}
+		return newBloomFilter(n, p)
+	default:
+		return nil
+	}
+}
+
+type exactSet map[string]struct{}
+
+func (set exactSet) seen(s string) bool {
+	if _, ok := set[s]; ok {
+		return true
+	}
+	set[s] = struct{}{}
+	return false
+}
+
+// bloomFilter is a Bloom filter using double hashing of a 128-bit FNV-1a hash.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a Bloom filter for n items at false-positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func (b *bloomFilter) seen(s string) bool {
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+	seen := true
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			seen = false
+			b.bits[word] |= mask
+		}
+	}
+	return seen
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 
+	"common/config"
 	"common/readfiles"
 )
 
@@ -27,7 +28,12 @@ func main() {
 	logFile := flag.String("log", "", "log to file.  (default stderr)")
 	outputFile := flag.String("out", "", "output to file.  (default stdout)")
 	flush := flag.Bool("flush", true, "flush after every output.")
+	readfiles.RegisterFlags(flag.CommandLine)
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "cat"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initiliazed before other parts using it
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 
+	"common/config"
 	"common/readfiles"
 )
 
@@ -46,7 +47,12 @@ func main() {
 	logFile := flag.String("log", "", "log to file.  (default stderr)")
 	outputFile := flag.String("out", "", "output to file.  (default stdout)")
 	flush := flag.Bool("flush", true, "flush after every output.")
+	readfiles.RegisterFlags(flag.CommandLine)
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "cut"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initiliazed before other parts using it
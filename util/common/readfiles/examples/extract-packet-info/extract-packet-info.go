@@ -1,6 +1,7 @@
 package main
 
 import (
+	"common/config"
 	"common/readfiles"
 	"encoding/csv"
 	"encoding/hex"
@@ -69,7 +70,11 @@ func main() {
 	outputFile := flag.String("out", "", "output csv file.  (default stdout)")
 	logFile := flag.String("log", "", "log to file.  (default stderr)")
 	flush := flag.Bool("flush", true, "flush after every output.")
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "extract-packet-info"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initiliazed before other parts using it
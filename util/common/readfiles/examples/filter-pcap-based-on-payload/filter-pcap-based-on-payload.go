@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"common/config"
 	"common/readfiles"
 	"encoding/hex"
 	"flag"
@@ -56,7 +57,11 @@ func main() {
 
 	outputFile := flag.String("out", "", "output pcap file.  (default stdout)")
 	logFile := flag.String("log", "", "log to file.  (default stderr)")
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "filter-pcap-based-on-payload"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initiliazed before other parts using it
@@ -0,0 +1,25 @@
+package readfiles
+
+import "flag"
+
+// RegisterFlags defines the command-line flags that configure DefaultOptions,
+// so that every tool reading its input with ReadFiles shares them.
+// usage example: in external function, RegisterFlags(flag.CommandLine) before flag.Parse()
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&DefaultOptions.SkipErrors, "skip-errors", false, "skip unreadable input files instead of stopping.")
+	fs.Var(&DefaultOptions.Delimiter, "delim", "`delimiter` of input records: lines (default), lf, crlf, nul, or any string with Go escapes, eg. \\t.")
+	fs.IntVar(&DefaultOptions.MaxRecordSize, "max-record", 0, "maximum length of an input record in bytes. 0 means unlimited.")
+	fs.Var(&DefaultOptions.Shard, "shard", "read only shard `k/n` of the input records, where 0 <= k < n. Records are assigned to shards by their hash, which is stable across runs and list orders.")
+	fs.Var(&DefaultOptions.Dedup, "dedup", "drop duplicate input records: off (default), exact (hash set), or approx (Bloom filter of bounded memory).")
+	fs.Float64Var(&DefaultOptions.DedupFPRate, "dedup-fp", defaultDedupFPRate, "false-positive rate of -dedup approx, ie. the fraction of distinct records dropped by mistake.")
+	fs.IntVar(&DefaultOptions.DedupCapacity, "dedup-capacity", defaultDedupCapacity, "number of distinct records -dedup approx is sized for.")
+	fs.BoolVar(&DefaultOptions.DedupNormalize, "dedup-normalize", false, "ignore case, surrounding whitespace and trailing dots when comparing records for -dedup.")
+	fs.Var(&DefaultOptions.Sample, "sample", "read a random sample of the input records: a number of records, eg. 10000, or a percentage, eg. 1%.")
+	fs.IntVar(&DefaultOptions.Shuffle, "shuffle", 0, "shuffle the input records within a window of this many records, in memory. 0 disables shuffling.")
+	fs.Int64Var(&DefaultOptions.Seed, "seed", 0, "random seed of -sample and -shuffle, to reproduce a run. 0 picks a random seed, which is logged.")
+	fs.BoolVar(&DefaultOptions.Follow, "follow", false, "keep reading input files as they grow, like tail -F, surviving rotation and truncation, and read new files matching the patterns. Compressed files and archives are read once, as a whole. Cannot be used with -shuffle or -sample N.")
+	fs.DurationVar(&DefaultOptions.FollowInterval, "follow-interval", defaultFollowInterval, "how often to check -follow files for new records.")
+	fs.Var(&DefaultOptions.Include, "include", "read only the files in directories, ** globs and archives matching a `pattern`, eg. '*.txt', and the files named explicitly. Patterns with a / match whole paths, in which ** matches any number of directories. Can be repeated.")
+	fs.Var(&DefaultOptions.Exclude, "exclude", "skip the files in directories, ** globs and archives, and the directories, matching a `pattern`. Can be repeated.")
+	fs.Var(&DefaultOptions.Oversize, "oversize", "`policy` for input records longer than -max-record: fail (default), skip or truncate.")
+}
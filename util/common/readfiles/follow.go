@@ -0,0 +1,211 @@
+package readfiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultFollowInterval = time.Second
+
+// follower reads the files matching a set of glob patterns as they grow.
+type follower struct {
+	patterns []string
+	opts     Options
+	files    []*followed
+}
+
+// followed is a file being followed. It is identified by the file itself
+// rather than by its path, so that a file renamed by a rotation is not read
+// again from its beginning.
+type followed struct {
+	name string
+	file *os.File
+	info os.FileInfo
+	// position after the last complete record read
+	line   int64
+	offset int64
+	// reading failed, and the file is skipped
+	failed bool
+	// the file is compressed or an archive, and has been read as a whole
+	whole bool
+}
+
+// follow reads the files matching filePaths like tail -F, passing their
+// records to emit, until ctx is done or an error happens:
+//   - files are read from their beginning, then again every opts.FollowInterval
+//     from the offset after their last complete record, so that nothing is read twice.
+//     A record not followed by a delimiter yet is left for the next read.
+//   - the patterns are expanded again every time, to pick up new matching files.
+//   - when a path is replaced by a new file, eg. by log rotation, the old file is
+//     read to its end and closed, and the new one is read from its beginning.
+//   - a file shrinking below its offset is considered truncated, and read again from its beginning.
+//
+// Compressed files and archives cannot be read while they are written: they are read once, as a whole,
+// when they are found, like files passed without -follow, eg. rotated logs compressed by logrotate.
+// Standard input cannot be followed. Followed files can neither be sampled with a reservoir,
+// which needs the end of the input, nor shuffled, which would hold back the last records
+// until newer ones arrive, maybe never.
+func follow(ctx context.Context, filePaths []string, opts Options, emit func(Record) bool) error {
+	if opts.Sample.N > 0 {
+		return errors.New("cannot sample a number of records of followed files, as they have no end")
+	}
+	if opts.Shuffle > 0 {
+		return errors.New("cannot shuffle followed files, as their last records would wait for newer ones")
+	}
+	interval := opts.FollowInterval
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+	f := &follower{patterns: filePaths, opts: opts}
+	defer f.close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.poll(ctx, emit); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll picks up the files matching the patterns, and reads the new records of all files.
+func (f *follower) poll(ctx context.Context, emit func(Record) bool) error {
+	paths, err := expandPaths(f.patterns, f.opts)
+	if err != nil {
+		return err
+	}
+	matched := make(map[*followed]bool)
+	for _, path := range paths {
+		if path == "-" {
+			return errors.New("cannot follow standard input")
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			// removed since it matched, or not a file
+			continue
+		}
+		fl := f.lookup(info)
+		if fl == nil {
+			fl, err = openFollowed(path)
+			if err != nil {
+				if f.opts.SkipErrors {
+					log.Println("skipping input", path, err)
+					continue
+				}
+				return err
+			}
+			f.files = append(f.files, fl)
+		}
+		matched[fl] = true
+	}
+	kept := f.files[:0]
+	for _, fl := range f.files {
+		if !fl.failed {
+			if err := fl.read(ctx, f.opts, emit); err != nil {
+				if !f.opts.SkipErrors || ctx.Err() != nil {
+					return err
+				}
+				log.Println("skipping input", fl.name, err)
+				fl.failed = true
+			}
+		}
+		// files no longer matching any pattern, eg. removed or rotated away, are read for the last time.
+		if matched[fl] {
+			kept = append(kept, fl)
+		} else {
+			fl.file.Close()
+		}
+	}
+	f.files = kept
+	return nil
+}
+
+// lookup returns the followed file described by info, or nil.
+func (f *follower) lookup(info os.FileInfo) *followed {
+	for _, fl := range f.files {
+		if os.SameFile(fl.info, info) {
+			return fl
+		}
+	}
+	return nil
+}
+
+func (f *follower) close() {
+	for _, fl := range f.files {
+		fl.file.Close()
+	}
+}
+
+func openFollowed(path string) (*followed, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &followed{name: path, file: file, info: info}, nil
+}
+
+// read passes the complete records appended since the last read to emit.
+func (fl *followed) read(ctx context.Context, opts Options, emit func(Record) bool) error {
+	if fl.whole {
+		return nil
+	}
+	info, err := fl.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < fl.offset {
+		log.Println(fl.name, "truncated, reading it again from its beginning")
+		fl.line, fl.offset = 0, 0
+	}
+	if info.Size() == fl.offset {
+		return nil
+	}
+	if fl.offset == 0 {
+		head := make([]byte, len(magicXz))
+		n, _ := fl.file.ReadAt(head, 0)
+		if isArchive(fl.name) || isCompressed(head[:n]) {
+			log.Println(fl.name, "is compressed or an archive, reading it as a whole instead of following it")
+			fl.whole = true
+			return readSource(ctx, fileSource(fl.name), opts, emit)
+		}
+	}
+	if _, err := fl.file.Seek(fl.offset, io.SeekStart); err != nil {
+		return err
+	}
+	rr := newRecordReader(fl.file, opts)
+	rr.line, rr.consumed = fl.line, fl.offset
+	for {
+		text, err := rr.next()
+		if err == io.EOF || (err == nil && rr.partial) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v:%v: %w", fl.name, rr.line, err)
+		}
+		fl.line, fl.offset = rr.line, rr.offset+rr.size
+		rec := Record{
+			Source: fl.name,
+			Line:   rr.line,
+			Offset: rr.offset,
+			Size:   rr.size,
+			Text:   string(text),
+		}
+		if !emit(rec) {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package readfiles
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// pipeline filters and reorders the records of all inputs before they are sent out.
+// The stages run in this order: parse, shard, dedup, sample, shuffle.
+type pipeline struct {
+	opts  Options
+	send  func(Record) bool
+	dedup deduper
+	rng   *rand.Rand
+	// shuffle window, or reservoir of reservoir sampling
+	buf []Record
+	// number of records offered to the reservoir
+	offered int64
+}
+
+// newPipeline returns a pipeline passing the records it keeps to send,
+// which returns false when the reading should stop.
+func newPipeline(opts Options, send func(Record) bool) *pipeline {
+	p := &pipeline{opts: opts, send: send, dedup: newDeduper(opts)}
+	if opts.Shuffle > 0 || opts.Sample.N > 0 || opts.Sample.Fraction > 0 {
+		seed := opts.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+			// a shuffled or sampled run can be reproduced from its seed
+			log.Println("shuffling or sampling input with random seed", seed)
+		}
+		p.rng = rand.New(rand.NewSource(seed))
+	}
+	return p
+}
+
+func (p *pipeline) push(r Record) bool {
+	if p.opts.Parse != nil {
+		var ok bool
+		if r, ok = p.opts.Parse(r); !ok {
+			return true
+		}
+	}
+	if !p.opts.Shard.Keep(r.Text) {
+		return true
+	}
+	if p.dedup != nil {
+		key := r.Text
+		if p.opts.DedupNormalize {
+			key = NormalizeName(key)
+		}
+		if p.dedup.seen(key) {
+			return true
+		}
+	}
+	if p.opts.Sample.Fraction > 0 && p.rng.Float64() >= p.opts.Sample.Fraction {
+		return true
+	}
+	if n := p.opts.Sample.N; n > 0 {
+		// reservoir sampling, algorithm R
+		p.offered++
+		if int64(len(p.buf)) < n {
+			p.buf = append(p.buf, r)
+		} else if i := p.rng.Int63n(p.offered); i < n {
+			p.buf[i] = r
+		}
+		return true
+	}
+	if w := p.opts.Shuffle; w > 0 {
+		// windowed shuffle: once the window is full, send a random record
+		// of it and put the new one in its place.
+		if len(p.buf) < w {
+			p.buf = append(p.buf, r)
+			return true
+		}
+		i := p.rng.Intn(w)
+		r, p.buf[i] = p.buf[i], r
+	}
+	return p.send(r)
+}
+
+// flush sends the records held back for shuffling or sampling, in random order.
+func (p *pipeline) flush() bool {
+	if p.rng != nil {
+		p.rng.Shuffle(len(p.buf), func(i, j int) { p.buf[i], p.buf[j] = p.buf[j], p.buf[i] })
+	}
+	for _, r := range p.buf {
+		if !p.send(r) {
+			return false
+		}
+	}
+	p.buf = nil
+	return true
+}
@@ -1,66 +1,299 @@
 package readfiles
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-// GetFiles return a slice of opened files.
-// usage example: in external function, files := GetFiles(flag.Args())
-func GetFiles(filePaths []string) []*os.File {
-	files := make([]*os.File, 0)
+// Options configures ReadFilesContext.
+type Options struct {
+	// Capacity is the capacity of the returned line channel. (default 100000)
+	Capacity int
+	// SkipErrors logs errors of individual files and moves on to the next file,
+	// instead of stopping at the first error.
+	SkipErrors bool
+	// Delimiter separates records. (default: lines ending in "\n" or "\r\n")
+	Delimiter Delimiter
+	// MaxRecordSize is the maximum length of a record in bytes. 0 means unlimited.
+	MaxRecordSize int
+	// Oversize decides what to do with records longer than MaxRecordSize. (default OversizeFail)
+	Oversize OversizePolicy
+	// Shard selects a deterministic subset of the records. (default all records)
+	Shard Shard
+	// Dedup drops records seen before, across all files. (default DedupOff)
+	Dedup DedupMode
+	// DedupFPRate is the false-positive rate of DedupApprox. (default 0.001)
+	DedupFPRate float64
+	// DedupCapacity is the number of distinct records DedupApprox is sized for. (default 10000000)
+	DedupCapacity int
+	// DedupNormalize compares records after NormalizeName, ie. ignoring case, whitespace and trailing dots.
+	DedupNormalize bool
+	// Sample selects a random subset of the records. (default all records)
+	Sample Sample
+	// Shuffle is the size of the window in which records are shuffled, in memory. 0 disables shuffling.
+	// A window as large as the input shuffles it entirely.
+	Shuffle int
+	// Seed seeds Sample and Shuffle, to reproduce a run. 0 picks a random seed, which is logged.
+	Seed int64
+	// Follow keeps reading the files as they grow, like tail -F, until the context is done.
+	// See follow for the details.
+	Follow bool
+	// FollowInterval is how often followed files are checked for new records. (default 1s)
+	FollowInterval time.Duration
+	// Include keeps only the files found in directories and by ** patterns, and the archive members,
+	// matching one of these patterns, if any. The files named by the paths, and standard input, are always kept.
+	Include Patterns
+	// Exclude drops the files found in directories and by ** patterns, and the archive members,
+	// matching one of these patterns, and skips the directories matching them.
+	Exclude Patterns
+	// Parse, if not nil, is called on every record, in input order, before Shard, Dedup, Sample
+	// and Shuffle, so that they act on what the records hold rather than on their raw text,
+	// eg. on the names of a domain list. It returns the record to keep, or false to drop it.
+	Parse func(Record) (Record, bool)
+}
+
+// DefaultOptions are the options used by ReadFiles. RegisterFlags binds them to command-line flags.
+var DefaultOptions Options
+
+const defaultCapacity = 100000
+
+// expandPaths expands the glob patterns in filePaths, and the directories they match into the files under them,
+// recursively and following symbolic links. "**" in a pattern matches any number of directories.
+// The files found in directories and by "**" are filtered by opts.Include and opts.Exclude, but not the files
+// the patterns match otherwise, which are named by the caller. Standard input is represented by "-".
+func expandPaths(filePaths []string, opts Options) ([]string, error) {
+	paths := make([]string, 0)
 	if len(filePaths) == 0 {
-		files = append(files, os.Stdin)
+		paths = append(paths, "-")
 	}
+	w := newWalker(opts)
 	for _, path := range filePaths {
 		if path == "-" {
-			files = append(files, os.Stdin)
+			paths = append(paths, "-")
 		} else if strings.HasPrefix(path, "~") {
 			// it's better not to expand ~, as it may not be consistent with the shell's behavior. For example,
 			// sudo ./program ~/path/to/*.pcap would be expanded by shell to /home/user/path/to/*.pcap
 			// sudo ./program "~/path/to/*.pcap" would be handled by this program,
 			// and if we use os.USHomeDir() to replace ~, it would be expanded to /root/path/to/*.pcap
-			log.Panicln("Please use absolute path instead of ~ to avoid unexpected behavior.")
+			return nil, errors.New("Please use absolute path instead of ~ to avoid unexpected behavior.")
+		} else if strings.Contains(path, "**") {
+			matches, err := w.globstar(path)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
 		} else {
 			matches, err := filepath.Glob(path)
 			if err != nil {
-				log.Panicln(err)
+				return nil, fmt.Errorf("invalid pattern %+q: %w", path, err)
 			}
-			for _, p := range matches {
-				file, err := os.Open(p)
+			for _, match := range matches {
+				if info, err := os.Stat(match); err != nil || !info.IsDir() {
+					paths = append(paths, match)
+					continue
+				}
+				files, err := w.walk(match, func(string) bool { return true })
 				if err != nil {
-					log.Panicln(err)
+					if !opts.SkipErrors {
+						return nil, err
+					}
+					log.Println("skipping input", match, err)
 				}
-				files = append(files, file)
+				paths = append(paths, files...)
 			}
 		}
 	}
+	return paths, nil
+}
 
+// OpenFiles is like GetFiles, but returns an error instead of panicking.
+// On error, the files opened so far are closed.
+// Prefer GetSources, which does not keep all files open at once.
+// Archives are opened as a whole; ReadFiles reads their members.
+func OpenFiles(filePaths []string) ([]*os.File, error) {
+	paths, err := expandPaths(filePaths, DefaultOptions)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, 0, len(paths))
+	for _, path := range paths {
+		if path == "-" {
+			files = append(files, os.Stdin)
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// GetFiles return a slice of opened files.
+// usage example: in external function, files := GetFiles(flag.Args())
+func GetFiles(filePaths []string) []*os.File {
+	files, err := OpenFiles(filePaths)
+	if err != nil {
+		log.Panicln(err)
+	}
 	return files
 }
 
-// ReadFiles return a channel and then sequentially pipe all lines in filePaths to the channel in a non-blocking way.
-// usage example: in external function, lines := ReadFiles(flag.Args())
-func ReadFiles(filePaths []string) chan string {
-	const maxNumLines = 100000
-	lines := make(chan string, maxNumLines)
+// Record is a record read from an input, along with where it came from.
+type Record struct {
+	// Source is the path of the input, or "-" for standard input.
+	Source string
+	// Line is the 1-based number of the record in the input.
+	Line int64
+	// Offset is the byte offset of the record in the input, after decompression.
+	Offset int64
+	// Size is the number of bytes the record takes in the input, including its delimiter.
+	Size int64
+	// Text is the record without its delimiter.
+	Text string
+}
+
+// ReadFilesContext sequentially pipes all lines in filePaths to the returned line channel in a non-blocking way.
+// gzip, zstd, xz and bzip2 compressed files (including stdin) are decompressed transparently.
+// The line channel is closed when all files have been read, when an error happens, or when ctx is done.
+// The error channel then receives the error that stopped the reading, if any, and is closed;
+// it is buffered, so it is fine to check it only after the line channel is drained.
+// usage example: in external function,
+//
+//	lines, errs := ReadFilesContext(ctx, flag.Args(), Options{})
+//	for line := range lines { ... }
+//	if err := <-errs; err != nil { ... }
+func ReadFilesContext(ctx context.Context, filePaths []string, opts Options) (<-chan string, <-chan error) {
+	return readFiles(ctx, filePaths, opts, nil, recordText)
+}
+
+// ReadRecords is like ReadFilesContext, but pipes records carrying their source, line number and offset.
+// usage example: in external function, records, errs := ReadRecords(ctx, flag.Args(), DefaultOptions)
+func ReadRecords(ctx context.Context, filePaths []string, opts Options) (<-chan Record, <-chan error) {
+	return readFiles(ctx, filePaths, opts, nil, func(r Record) Record { return r })
+}
+
+func recordText(r Record) string { return r.Text }
+
+// readFiles implements ReadFilesContext and ReadRecords, piping convert(record) for every record.
+// If fail is not nil, it is called with the error that stopped the reading before the output channel is closed.
+func readFiles[T any](ctx context.Context, filePaths []string, opts Options, fail func(error), convert func(Record) T) (chan T, chan error) {
+	capacity := opts.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	out := make(chan T, capacity)
+	errs := make(chan error, 1)
 	go func() {
-		files := GetFiles(filePaths)
-		for _, file := range files {
-			scanner := bufio.NewScanner(file)
-			// optionally, resize scanner's capacity for lines over 64K
-			if err := scanner.Err(); err != nil {
-				log.Panicln(err)
+		defer close(errs)
+		defer close(out)
+		pipe := newPipeline(opts, func(r Record) bool {
+			select {
+			case out <- convert(r):
+				return true
+			case <-ctx.Done():
+				return false
 			}
-			for scanner.Scan() {
-				lines <- scanner.Text()
+		})
+		var err error
+		if opts.Follow {
+			err = follow(ctx, filePaths, opts, pipe.push)
+		} else {
+			err = readSources(ctx, filePaths, opts, pipe)
+		}
+		if err != nil {
+			if fail != nil {
+				fail(err)
 			}
-			file.Close()
+			errs <- err
 		}
-		close(lines)
 	}()
+	return out, errs
+}
+
+// readSources reads all sources in filePaths into pipe, one after the other.
+func readSources(ctx context.Context, filePaths []string, opts Options, pipe *pipeline) error {
+	sources, err := getSources(filePaths, opts)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		err := readSource(ctx, source, opts, pipe.push)
+		if err == nil {
+			continue
+		}
+		if opts.SkipErrors && ctx.Err() == nil {
+			log.Println("skipping input", source.Name(), err)
+			continue
+		}
+		return err
+	}
+	if !pipe.flush() {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// readSource opens source, passes all its records to emit, and closes it right after.
+// The members of archives are read as separate inputs. emit returns false when ctx is done.
+func readSource(ctx context.Context, source Source, opts Options, emit func(Record) bool) error {
+	if isArchive(source.Name()) {
+		return readArchive(ctx, source, opts, emit)
+	}
+	file, err := source.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%v: %w", source.Name(), err)
+	}
+	defer r.Close()
+	rr := newRecordReader(r, opts)
+	for {
+		text, err := rr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v:%v: %w", source.Name(), rr.line, err)
+		}
+		rec := Record{
+			Source: source.Name(),
+			Line:   rr.line,
+			Offset: rr.offset,
+			Size:   rr.size,
+			Text:   string(text),
+		}
+		if !emit(rec) {
+			return ctx.Err()
+		}
+	}
+}
+
+// ReadFiles return a channel and then sequentially pipe all lines in filePaths to the channel in a non-blocking way.
+// gzip, zstd, xz and bzip2 compressed files (including stdin) are decompressed transparently.
+// It reads with DefaultOptions and panics on any error; use ReadFilesContext to handle errors.
+// usage example: in external function, lines := ReadFiles(flag.Args())
+func ReadFiles(filePaths []string) chan string {
+	lines, _ := readFiles(context.Background(), filePaths, DefaultOptions, func(err error) {
+		log.Panicln(err)
+	}, recordText)
 	return lines
 }
@@ -0,0 +1,211 @@
+package readfiles
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Delimiter separates records in the input.
+// The zero value splits on "\n" and drops a trailing "\r", like bufio.ScanLines.
+type Delimiter string
+
+const (
+	LF   Delimiter = "\n"
+	CRLF Delimiter = "\r\n"
+	NUL  Delimiter = "\x00"
+)
+
+var delimiterNames = map[string]Delimiter{
+	"lf":   LF,
+	"crlf": CRLF,
+	"nul":  NUL,
+}
+
+func (d *Delimiter) String() string {
+	if d == nil || *d == "" {
+		return "lines"
+	}
+	for name, v := range delimiterNames {
+		if *d == v {
+			return name
+		}
+	}
+	return strconv.Quote(string(*d))
+}
+
+// Set parses lf, crlf, nul, lines (the default), or any other non-empty string with Go escapes, eg. "\t".
+func (d *Delimiter) Set(s string) error {
+	if s == "lines" {
+		*d = ""
+		return nil
+	}
+	if v, ok := delimiterNames[strings.ToLower(s)]; ok {
+		*d = v
+		return nil
+	}
+	unquoted, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return fmt.Errorf("invalid delimiter %+q: %w", s, err)
+	}
+	if unquoted == "" {
+		return errors.New("empty delimiter")
+	}
+	*d = Delimiter(unquoted)
+	return nil
+}
+
+// OversizePolicy decides what to do with records longer than Options.MaxRecordSize.
+type OversizePolicy int
+
+const (
+	// OversizeFail stops reading the input with ErrRecordTooLong.
+	OversizeFail OversizePolicy = iota
+	// OversizeSkip drops the record.
+	OversizeSkip
+	// OversizeTruncate keeps the first MaxRecordSize bytes of the record.
+	OversizeTruncate
+)
+
+var oversizeNames = []string{"fail", "skip", "truncate"}
+
+func (p *OversizePolicy) String() string {
+	if p == nil || int(*p) >= len(oversizeNames) {
+		return oversizeNames[0]
+	}
+	return oversizeNames[*p]
+}
+
+func (p *OversizePolicy) Set(s string) error {
+	for i, name := range oversizeNames {
+		if s == name {
+			*p = OversizePolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid oversize policy %+q: must be one of %v", s, strings.Join(oversizeNames, ", "))
+}
+
+// ErrRecordTooLong is returned when a record is longer than Options.MaxRecordSize
+// and the policy is OversizeFail.
+var ErrRecordTooLong = errors.New("record too long")
+
+// recordReader reads delimited records of any length.
+type recordReader struct {
+	r      *bufio.Reader
+	delim  []byte
+	dropCR bool
+	max    int
+	policy OversizePolicy
+	buf    []byte
+	// position of the last record read
+	line   int64
+	offset int64
+	size   int64
+	// the last record read is not followed by a delimiter
+	partial bool
+	// number of bytes consumed so far
+	consumed int64
+}
+
+func newRecordReader(r io.Reader, opts Options) *recordReader {
+	rr := &recordReader{
+		r:      bufio.NewReaderSize(r, 64*1024),
+		delim:  []byte(opts.Delimiter),
+		max:    opts.MaxRecordSize,
+		policy: opts.Oversize,
+	}
+	if len(rr.delim) == 0 {
+		rr.delim = []byte(LF)
+		rr.dropCR = true
+	}
+	return rr
+}
+
+// next returns the next record without its delimiter. The returned slice is
+// only valid until the following call. It returns io.EOF after the last record.
+func (rr *recordReader) next() ([]byte, error) {
+	for {
+		rec, oversize, err := rr.read()
+		if err != nil {
+			return nil, err
+		}
+		if !oversize {
+			return rec, nil
+		}
+		switch rr.policy {
+		case OversizeSkip:
+			log.Println("skipping a record longer than", rr.max, "bytes")
+		case OversizeTruncate:
+			return rec[:rr.max], nil
+		default:
+			return nil, fmt.Errorf("%w (over %v bytes)", ErrRecordTooLong, rr.max)
+		}
+	}
+}
+
+// read reads up to and including the next delimiter. Once the record grows
+// beyond the maximum size, only its first max bytes are kept in memory.
+// It updates the position of the record, also when the record is oversize.
+func (rr *recordReader) read() (rec []byte, oversize bool, err error) {
+	rr.buf = rr.buf[:0]
+	rr.partial = false
+	rr.line++
+	rr.offset = rr.consumed
+	defer func() {
+		rr.size = rr.consumed - rr.offset
+	}()
+	last := rr.delim[len(rr.delim)-1]
+	// bytes after the first max ones, which are kept only to match a
+	// delimiter spanning two reads.
+	tailLen := len(rr.delim) - 1
+	for {
+		chunk, err := rr.r.ReadSlice(last)
+		rr.buf = append(rr.buf, chunk...)
+		rr.consumed += int64(len(chunk))
+		if err == nil && bytes.HasSuffix(rr.buf, rr.delim) {
+			rec = rr.buf[:len(rr.buf)-len(rr.delim)]
+			if oversize {
+				rec = rr.buf[:rr.max]
+			} else if rr.dropCR && len(rec) > 0 && rec[len(rec)-1] == '\r' {
+				rec = rec[:len(rec)-1]
+			}
+			return rec, oversize || rr.tooLong(rec), nil
+		}
+		if err == io.EOF {
+			if len(rr.buf) == 0 {
+				rr.line--
+				return nil, false, io.EOF
+			}
+			// the last record is not followed by a delimiter
+			rr.partial = true
+			if oversize {
+				return rr.buf[:rr.max], true, nil
+			}
+			rec = rr.buf
+			if rr.dropCR && rec[len(rec)-1] == '\r' {
+				rec = rec[:len(rec)-1]
+			}
+			return rec, rr.tooLong(rec), nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, false, err
+		}
+		if rr.max > 0 && len(rr.buf) > rr.max+tailLen {
+			if rr.policy == OversizeFail {
+				return nil, true, fmt.Errorf("%w (over %v bytes)", ErrRecordTooLong, rr.max)
+			}
+			oversize = true
+			rr.buf = append(rr.buf[:rr.max], rr.buf[len(rr.buf)-tailLen:]...)
+		}
+	}
+}
+
+func (rr *recordReader) tooLong(rec []byte) bool {
+	return rr.max > 0 && len(rec) > rr.max
+}
@@ -0,0 +1,53 @@
+package readfiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sample selects a random subset of the records: either N records by
+// reservoir sampling, or every record with probability Fraction (Bernoulli
+// sampling). The zero value selects every record.
+type Sample struct {
+	N        int64
+	Fraction float64
+}
+
+// ParseSample parses a number of records, eg. "10000", or a percentage, eg. "1%" or "0.5%".
+func ParseSample(s string) (Sample, error) {
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		f, err := strconv.ParseFloat(pct, 64)
+		if err != nil || f <= 0 || f > 100 {
+			return Sample{}, fmt.Errorf("invalid sample %+q: want a percentage in (0, 100]", s)
+		}
+		return Sample{Fraction: f / 100}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return Sample{}, fmt.Errorf("invalid sample %+q: want a positive number of records or a percentage", s)
+	}
+	return Sample{N: n}, nil
+}
+
+func (s *Sample) String() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.N > 0:
+		return strconv.FormatInt(s.N, 10)
+	case s.Fraction > 0:
+		return strconv.FormatFloat(s.Fraction*100, 'f', -1, 64) + "%"
+	default:
+		return ""
+	}
+}
+
+func (s *Sample) Set(v string) error {
+	sample, err := ParseSample(v)
+	if err != nil {
+		return err
+	}
+	*s = sample
+	return nil
+}
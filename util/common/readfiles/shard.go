@@ -0,0 +1,54 @@
+package readfiles
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Shard selects the records whose hash modulo N is K, so that a list can be
+// split among N vantage points. The assignment depends only on the text of a
+// record, so it is stable across runs and reorderings of the list.
+// The zero value selects every record.
+type Shard struct {
+	K, N int
+}
+
+// ParseShard parses "k/n", where 0 <= k < n.
+func ParseShard(s string) (Shard, error) {
+	var sh Shard
+	var rest string
+	n, _ := fmt.Sscanf(s, "%d/%d%s", &sh.K, &sh.N, &rest)
+	if n != 2 {
+		return Shard{}, fmt.Errorf("invalid shard %+q: want k/n, eg. 0/2", s)
+	}
+	if sh.N < 1 || sh.K < 0 || sh.K >= sh.N {
+		return Shard{}, fmt.Errorf("invalid shard %+q: want 0 <= k < n", s)
+	}
+	return sh, nil
+}
+
+// Keep reports whether the record with text belongs to the shard.
+func (sh Shard) Keep(text string) bool {
+	if sh.N <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return h.Sum64()%uint64(sh.N) == uint64(sh.K)
+}
+
+func (sh *Shard) String() string {
+	if sh == nil || sh.N == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", sh.K, sh.N)
+}
+
+func (sh *Shard) Set(s string) error {
+	v, err := ParseShard(s)
+	if err != nil {
+		return err
+	}
+	*sh = v
+	return nil
+}
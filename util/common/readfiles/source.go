@@ -0,0 +1,53 @@
+package readfiles
+
+import (
+	"io"
+	"os"
+)
+
+// Source is an input which is opened only when reading begins,
+// so that thousands of inputs do not hold thousands of file descriptors.
+type Source interface {
+	// Name returns the path of the input, or "-" for standard input.
+	Name() string
+	// Open opens the input for reading. The caller must close it.
+	Open() (io.ReadCloser, error)
+}
+
+type fileSource string
+
+func (s fileSource) Name() string { return string(s) }
+
+func (s fileSource) Open() (io.ReadCloser, error) { return os.Open(string(s)) }
+
+type stdinSource struct{}
+
+func (stdinSource) Name() string { return "-" }
+
+// Open does not hand out os.Stdin itself, so that closing it does not close standard input.
+func (stdinSource) Open() (io.ReadCloser, error) { return io.NopCloser(os.Stdin), nil }
+
+// GetSources return a slice of sources in filePaths, without opening them.
+// It follows the same rules as GetFiles: with no path, or when a path is -, read standard input,
+// and other paths are glob patterns or directories, filtered by the Include and Exclude patterns of DefaultOptions.
+// An archive is a single source, whose members ReadFiles reads as separate inputs.
+// usage example: in external function, sources, err := GetSources(flag.Args())
+func GetSources(filePaths []string) ([]Source, error) {
+	return getSources(filePaths, DefaultOptions)
+}
+
+func getSources(filePaths []string, opts Options) ([]Source, error) {
+	paths, err := expandPaths(filePaths, opts)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Source, 0, len(paths))
+	for _, path := range paths {
+		if path == "-" {
+			sources = append(sources, stdinSource{})
+		} else {
+			sources = append(sources, fileSource(path))
+		}
+	}
+	return sources, nil
+}
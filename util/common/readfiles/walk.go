@@ -0,0 +1,171 @@
+package readfiles
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Patterns is a list of glob patterns, such as Options.Include. As a flag, it
+// can be given several times, or as a comma-separated list.
+//
+// A pattern without a path separator matches base names, like find -name.
+// Other patterns match whole paths, where "**" matches any number of directories.
+type Patterns []string
+
+func (p *Patterns) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, ",")
+}
+
+func (p *Patterns) Set(s string) error {
+	for _, pattern := range strings.Split(s, ",") {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %+q: %w", pattern, err)
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+// Match reports whether path matches any of the patterns.
+func (p Patterns) Match(path string) bool {
+	for _, pattern := range p {
+		if matchPattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, path string) bool {
+	if !strings.Contains(filepath.ToSlash(pattern), "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}
+	return matchParts(splitPath(pattern), splitPath(path))
+}
+
+func splitPath(path string) []string {
+	return strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+// matchParts matches the components of a path against those of a pattern, in which "**" matches any number of components.
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// keepFile reports whether the file at path passes the Include and Exclude patterns of opts.
+// Archives need not match Include, which applies to their members instead.
+func keepFile(opts Options, path string) bool {
+	if len(opts.Include) > 0 && !isArchive(path) && !opts.Include.Match(path) {
+		return false
+	}
+	return !opts.Exclude.Match(path)
+}
+
+// walker finds the files in directories, recursively and following symbolic links.
+type walker struct {
+	opts Options
+	// real paths of the directories walked, so that a symbolic link cycle,
+	// or a directory reached by several links, is walked only once.
+	visited map[string]bool
+}
+
+func newWalker(opts Options) *walker {
+	return &walker{opts: opts, visited: make(map[string]bool)}
+}
+
+// walk returns the files under dir, in lexical order, for which match returns true.
+// The directories matching the Exclude patterns are skipped.
+func (w *walker) walk(dir string, match func(string) bool) ([]string, error) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if w.visited[real] {
+		return nil, nil
+	}
+	w.visited[real] = true
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if w.opts.Exclude.Match(path) {
+			continue
+		}
+		// Stat follows symbolic links, unlike entry.Info
+		info, err := os.Stat(path)
+		if err != nil {
+			// eg. a dangling symbolic link
+			log.Println("skipping", path, err)
+			continue
+		}
+		if info.IsDir() {
+			sub, err := w.walk(path, match)
+			if err != nil {
+				if !w.opts.SkipErrors {
+					return nil, err
+				}
+				log.Println("skipping input", path, err)
+			}
+			files = append(files, sub...)
+		} else if info.Mode().IsRegular() && match(path) && keepFile(w.opts, path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// globstar returns the files matching pattern, in which "**" matches any number of directories.
+func (w *walker) globstar(pattern string) ([]string, error) {
+	parts := splitPath(pattern)
+	for _, part := range parts {
+		if _, err := filepath.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %+q: %w", pattern, err)
+		}
+	}
+	// walk from the longest directory without wildcards
+	i := 0
+	for i < len(parts)-1 && !strings.ContainsAny(parts[i], `*?[\`) {
+		i++
+	}
+	root := filepath.Join(parts[:i]...)
+	if filepath.IsAbs(pattern) {
+		root = string(filepath.Separator) + root
+	}
+	if root == "" {
+		root = "."
+	}
+	if _, err := os.Stat(root); err != nil {
+		// like filepath.Glob, a pattern matching nothing is not an error
+		return nil, nil
+	}
+	return w.walk(root, func(path string) bool {
+		return matchParts(parts, splitPath(path))
+	})
+}
@@ -2,13 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"iter"
 	"log"
+	"math/rand/v2"
 	"net"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"common/config"
+	"common/domainlist"
 	"common/parseipportargs"
 	"common/readfiles"
 
@@ -20,7 +33,7 @@ func usage() {
     %[1]s [OPTION]... [FILE]...
 
 Description:
-    Send DNS queries of domains in FILE(s) at a very fast speed. With no FILE, or when FILE is -, read standard input. The program takes a send-and-forget approach, meaning it does not capture any responses. Capture responses yourself with tcpdump or wireshark.
+    Send DNS queries of domains in FILE(s) at a very fast speed. With no FILE, or when FILE is -, read standard input. Compressed FILE(s) (gzip, zstd, xz, bzip2) are decompressed transparently. A directory FILE is read recursively, and the members of tar and zip archives are read as separate inputs. By default, the program takes a send-and-forget approach, meaning it does not capture any responses: capture them yourself with tcpdump or wireshark. With -receive, it reads the replies itself, matches them to the queries by ID, resolver and question, and writes a row per reply to -out.
 
 Examples:
     Send a type A and a type AAAA query of www.google.com to port 53 of 1.1.1.1
@@ -35,83 +48,230 @@ Options:
 	flag.PrintDefaults()
 }
 
-func query(transport *net.UDPConn, remoteUDPAddr net.UDPAddr, labels [][]byte, RRType uint16) error {
+func query(labels [][]byte, RRType uint16, class parseipportargs.RRClass, id uint16) ([]byte, error) {
 	name, err := dns.NewName(labels)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// var id uint16
-	// binary.Read(rand.Reader, binary.BigEndian, &id)
 	query := &dns.Message{
-		ID:    0x0000,
+		ID:    id,
 		Flags: 0x0100, // QR = 0, RD = 1
 		Question: []dns.Question{
 			{
 				Name:  name,
 				Type:  RRType,
-				Class: dns.ClassIN,
+				Class: uint16(class),
 			},
 		},
 	}
-	buf, err := query.WireFormat()
+	return query.WireFormat()
+}
+
+// sender sends queries to UDP and TCP endpoints. UDP queries share one socket,
+// and TCP queries to an endpoint share one connection, which is opened on the first query.
+// With a receiver, the replies are read on the socket and the connections.
+type sender struct {
+	udp  *net.UDPConn
+	tcp  map[netip.AddrPort]net.Conn
+	recv *receiver
+	// goroutines reading replies
+	readers sync.WaitGroup
+}
+
+func newSender(recv *receiver) (*sender, error) {
+	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
+		return nil, err
+	}
+	s := &sender{udp: conn, tcp: make(map[netip.AddrPort]net.Conn), recv: recv}
+	if recv != nil {
+		s.readers.Add(1)
+		go func() {
+			defer s.readers.Done()
+			recv.readUDP(conn)
+		}()
+	}
+	return s, nil
+}
+
+func (s *sender) send(e parseipportargs.Endpoint, buf []byte) error {
+	if e.Proto == parseipportargs.UDP {
+		_, err := s.udp.WriteToUDPAddrPort(buf, e.AddrPort)
 		return err
 	}
+	conn, ok := s.tcp[e.AddrPort]
+	if !ok {
+		var err error
+		conn, err = net.DialTimeout("tcp", e.AddrPort.String(), tcpTimeout)
+		if err != nil {
+			return err
+		}
+		s.tcp[e.AddrPort] = conn
+		if s.recv != nil {
+			s.readers.Add(1)
+			go func() {
+				defer s.readers.Done()
+				s.recv.readTCP(conn, e.AddrPort)
+			}()
+		}
+	}
+	// over TCP, a message is prefixed by its length
+	// https://tools.ietf.org/html/rfc1035#section-4.2.2
+	msg := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(buf)), uint16(len(buf)))
+	msg = append(msg, buf...)
+	conn.SetWriteDeadline(time.Now().Add(tcpTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		// reconnect on the next query
+		conn.Close()
+		delete(s.tcp, e.AddrPort)
+		return err
+	}
+	return nil
+}
 
-	_, err = transport.WriteToUDP(buf, &remoteUDPAddr)
-	return err
+func (s *sender) close() {
+	s.udp.Close()
+	for _, conn := range s.tcp {
+		conn.Close()
+	}
+	s.readers.Wait()
 }
 
-func worker(id int, remoteUDPAddrs []net.UDPAddr, jobs chan string, RRTypes []uint16) {
-	conn, err := net.ListenUDP("udp", nil)
+// tcpTimeout is the timeout of connecting to, and writing to, TCP endpoints.
+const tcpTimeout = 5 * time.Second
+
+// worker sends the queries of the jobs. With replies, it matches the replies to them, marks a job done
+// in cp once the rows of its replies are written, and after its last query, waits for drain.
+func worker(id int, targets []parseipportargs.Target, jobs chan readfiles.Record, RRTypes []uint16, class parseipportargs.RRClass, provenance bool, cp *readfiles.Checkpoint, replies chan<- reply, drain time.Duration) {
+	recv := newReceiver(drain, replies)
+	s, err := newSender(recv)
 	if err != nil {
 		log.Println(err)
+		return
 	}
+	defer s.close()
 
-	numAddrs := len(remoteUDPAddrs)
-	counter := -1
+	// cycle through the resolvers lazily, so that any prefix fits in memory
+	next, stop := iter.Pull(parseipportargs.Endpoints(targets))
+	defer func() { stop() }()
 
-	for j := range jobs {
+	for rec := range jobs {
+		j := rec.Text
+		// where the domain came from, to trace weird domains back to the input
+		from := ""
+		if provenance {
+			from = fmt.Sprintf(" (%v:%v, offset %v)", rec.Source, rec.Line, rec.Offset)
+		}
 		for _, RRType := range RRTypes {
-			log.Printf("worker %v is sending type %v query of: %v\n", id, RRType, j)
+			log.Printf("worker %v is sending type %v query of: %v%v\n", id, parseipportargs.RRType(RRType), j, from)
 			for {
-				counter++
-				counter %= numAddrs
-				remoteUDPAddr := remoteUDPAddrs[counter]
+				endpoint, ok := next()
+				if !ok {
+					stop()
+					next, stop = iter.Pull(parseipportargs.Endpoints(targets))
+					endpoint, _ = next()
+				}
 
 				q := bytes.Split([]byte(j), []byte("."))
-				err := query(conn, remoteUDPAddr, q, RRType)
+				// a random ID, for replies to be told apart from those of other queries
+				queryID := uint16(rand.Uint32())
+				buf, err := query(q, RRType, class, queryID)
+				if err == nil {
+					recv.add(queryKey{endpoint.AddrPort, queryID, strings.ToLower(dns.Name(q).String()), RRType, uint16(class)},
+						pendingQuery{domain: j, proto: endpoint.Proto, at: time.Now(), source: rec.Source, line: rec.Line, offset: rec.Offset})
+					err = s.send(endpoint, buf)
+				}
 				if err != nil {
-					if err.Error() == "name contains a label longer than 63 octets" {
-
-					} else {
-						log.Println(err.Error(), j)
-						// comment out to avoid infinite loop when unexpected error
-						// continue
+					// names are validated by domainlist, so this is rather a network error
+					log.Println(err.Error(), endpoint, endpoint.Proto, j+from)
+					// comment out to avoid infinite loop when unexpected error
+					// continue
+				}
+				if parseipportargs.DefaultIPDB.Enabled() {
+					o, err := parseipportargs.DefaultIPDB.Lookup(endpoint.Addr())
+					if err != nil {
+						log.Println("failed to look up the origin of", endpoint, err)
 					}
+					log.Printf("worker %v sent it to %v (%v)\n", id, endpoint, o)
 				}
 				break
 			}
 		}
+		recv.sent(func() { cp.Done(rec) })
+		if cp.Due() {
+			if err := cp.Save(); err != nil {
+				log.Println("failed to save checkpoint", err)
+			}
+		}
 	}
+	recv.finish()
+}
+
+// meta is the metadata of a run, to reproduce its results.
+type meta struct {
+	Started time.Time `json:"started"`
+	Args    []string  `json:"args"`
+	Targets []string  `json:"targets"`
+	// IPs of the hostnames among the targets, resolved once at startup
+	Resolved map[string][]netip.Addr `json:"resolved,omitempty"`
+}
+
+// writeMeta writes m to the named file as JSON.
+func writeMeta(name string, m meta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// create creates or truncates the named file, or opens it for appending when resuming a run.
+func create(name string, resume bool) (*os.File, error) {
+	if resume {
+		return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	return os.Create(name)
 }
 
 func main() {
 	flag.Usage = usage
 	var port int
 	var maxNumWorkers int
-	ipArg := flag.String("dip", "127.0.0.1", "comma-separated list of destination IP addresses to which the program sends DNS queries. eg. 1.1.1.1,2.2.2.2")
-	RRTypeArg := flag.String("type", "A", "comma-separated list of DNS RR Type of the DNS queries. eg. A,AAAA,16-18")
-	flag.IntVar(&port, "p", 53, "the port to which the program sends DNS queries.")
+	ipArg := flag.String("dip", "", "targets to which the program sends DNS queries, separated by spaces or ;. A target is a comma-separated list of destination IP addresses, optionally followed by :ports and /udp or /tcp, eg. 1.1.1.1,2.2.2.0/24,3.3.3.3-3.3.3.9,@ips.txt or [2001:db8::1]:53,853/tcp, where asn:N and cc:XX select the IPs of an AS or a country in -ipdb, and @ips.txt reads one IP, CIDR or range per line of the files matching ips.txt, or stdin for @-. Queries over tcp are sent on one connection per ip:port. Required. Reserved networks, such as 127.0.0.0/8, require -allow-reserved.")
+	RRTypeArg := flag.String("type", "A", "comma-separated list of DNS RR Type of the DNS queries, by name, number or TYPEnnn. eg. A,AAAA,16-18,TYPE65534")
+	class := parseipportargs.ClassIN
+	flag.Var(&class, "class", "DNS class of the DNS queries: IN, CH, HS, ANY, or CLASSnnn.")
+	flag.IntVar(&port, "p", 53, "the port to which the program sends DNS queries, for the targets without ports.")
 	flag.IntVar(&maxNumWorkers, "worker", 100, "number of workers in parallel.")
 	logFile := flag.String("log", "", "log to file. (default stderr)")
+	provenance := flag.Bool("provenance", false, "log the input file, line number and byte offset of each domain, and append them to the replies of -receive.")
+	checkpointFile := flag.String("checkpoint", "", "save the progress to file periodically, to be able to -resume after a crash. Cannot be used with -shuffle or -sample N.")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "how often to save -checkpoint.")
+	resume := flag.Bool("resume", false, "skip the domains done according to -checkpoint, and append to -log and -rejects instead of overwriting them.")
+	var format domainlist.Format
+	flag.Var(&format, "format", "format of input files: auto (default), plain, csv (rank,domain) or zone (owner names of a zone file).")
+	rejectsFile := flag.String("rejects", "", "write input lines which are not valid domains to csv file, with the reason. (default drop them)")
+	receive := flag.Bool("receive", false, "read the replies, match them to the queries, and write them to -out, instead of sending and forgetting.")
+	outputFile := flag.String("out", "", "with -receive, output file of the replies.  (default stdout)")
+	outputFormat := flag.String("out-format", "csv", "format of -out: csv (timestamp,domain,type,resolver,proto,rcode,answers,rtt_ms) or ndjson.")
+	drain := flag.Duration("drain", 5*time.Second, "with -receive, how long to wait for the replies to a query, and after the last query before exiting.")
+	metaFile := flag.String("meta", "", "write the metadata of the run, such as the targets and the IPs their hostnames resolved to, to json file.")
+	readfiles.RegisterFlags(flag.CommandLine)
+	parseipportargs.RegisterFlags(flag.CommandLine)
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "dnscensor"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
+	if *ipArg == "" {
+		log.Panicln("-dip is required: give the targets to send the queries to, eg. -dip 1.1.1.1")
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initliazed before other parts using it
 	if *logFile != "" {
-		f, err := os.Create(*logFile)
+		f, err := create(*logFile, *resume)
 		if err != nil {
 			log.Panicln("failed to open log file", err)
 		}
@@ -119,38 +279,117 @@ func main() {
 		log.SetOutput(f)
 	}
 
-	ips, err := parseipportargs.ParseIPArgs(*ipArg)
-	if err != nil {
-		log.Panic(err)
+	if *resume && *checkpointFile == "" {
+		log.Panicln("-resume requires -checkpoint")
+	}
+	var cp *readfiles.Checkpoint
+	if *checkpointFile != "" {
+		if err := readfiles.CheckOrder(readfiles.DefaultOptions); err != nil {
+			log.Panicln(err)
+		}
+		var err error
+		cp, err = readfiles.NewCheckpoint(*checkpointFile, *checkpointInterval, *resume)
+		if err != nil {
+			log.Panicln("failed to load checkpoint", err)
+		}
+	}
+
+	var rejects *domainlist.Rejects
+	if *rejectsFile != "" {
+		var err error
+		rejects, err = domainlist.CreateRejects(*rejectsFile, *resume)
+		if err != nil {
+			log.Panicln("failed to open rejects file", err)
+		}
+		defer rejects.Close()
+	}
+
+	var replies chan reply
+	written := make(chan error, 1)
+	if *receive {
+		if *outputFormat != "csv" && *outputFormat != "ndjson" {
+			log.Panicln("invalid -out-format", *outputFormat, "must be csv or ndjson")
+		}
+		f := os.Stdout
+		if *outputFile != "" {
+			var err error
+			f, err = create(*outputFile, *resume)
+			if err != nil {
+				log.Panicln("failed to open output file", err)
+			}
+			defer f.Close()
+		}
+		replies = make(chan reply, 100)
+		go func() {
+			written <- writeReplies(f, *outputFormat, *provenance, replies)
+		}()
 	}
 
 	RRTypes, err := parseipportargs.ParseRRTypeArgs(*RRTypeArg)
 	if err != nil {
 		log.Panic(err)
 	}
+	for _, t := range RRTypes {
+		if parseipportargs.RRType(t).Flags()&parseipportargs.Meta != 0 {
+			log.Println("type", parseipportargs.RRType(t), "is a meta type, which servers are expected to reject in questions")
+		}
+	}
+	if class.Flags()&parseipportargs.Meta != 0 {
+		log.Println("class", class, "is a meta class, which servers are expected to reject in questions")
+	}
 
 	err = parseipportargs.ValidatePortRange(port)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	remoteUDPAddrs := make([]net.UDPAddr, 0)
-	for _, ip := range ips {
-		remoteUDPAddr := net.UDPAddr{IP: ip, Port: port}
-		remoteUDPAddrs = append(remoteUDPAddrs, remoteUDPAddr)
+	if err := parseipportargs.DefaultIPDB.Load(); err != nil {
+		log.Panic(err)
+	}
+	targets, err := parseipportargs.ParseTargets(*ipArg, parseipportargs.NewPortSet(port), parseipportargs.UDP)
+	if err != nil {
+		log.Panic(err)
+	}
+	if *metaFile != "" {
+		m := meta{Started: time.Now(), Args: os.Args, Resolved: parseipportargs.DefaultResolver.Resolutions()}
+		for _, t := range targets {
+			m.Targets = append(m.Targets, t.String())
+		}
+		if err := writeMeta(*metaFile, m); err != nil {
+			log.Panicln("failed to write metadata", err)
+		}
 	}
 
 	// The channel capacity does not have to be equal to the
 	// number of workers. It can be smaller.
-	jobs := make(chan string, 100)
-	lines := readfiles.ReadFiles(flag.Args())
+	jobs := make(chan readfiles.Record, 100)
+	// stop reading new domains on SIGINT or SIGTERM, and let the workers
+	// send the queries at hand.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// parse the domains before they are sharded, deduplicated, sampled or shuffled,
+	// every one of them, even skipped ones, to keep the state of zone files
+	opts := readfiles.DefaultOptions
+	opts.Parse = domainlist.NewParser(format).Filter(rejects, cp.Skip)
+	records, errs := readfiles.ReadRecords(ctx, flag.Args(), opts)
 
+	var readErr error
 	go func() {
-		for line := range lines {
+		skipped := 0
+		for rec := range records {
+			if cp.Skip(rec) {
+				skipped++
+				continue
+			}
+			cp.Start(rec)
 			// we can do more parsing of the lines if needed
 			// jobs are the domains to be tested
-			jobs <- line
+			jobs <- rec
 		}
+		if skipped > 0 {
+			log.Println("skipped", skipped, "domains done before resuming")
+		}
+		readErr = <-errs
 		close(jobs)
 	}()
 
@@ -159,8 +398,26 @@ func main() {
 	for id := 0; id < maxNumWorkers; id++ {
 		go func(id int) {
 			defer wg.Done()
-			worker(id, remoteUDPAddrs, jobs, RRTypes)
+			worker(id, targets, jobs, RRTypes, class, *provenance, cp, replies, *drain)
 		}(id)
 	}
 	wg.Wait()
+	var writeErr error
+	if replies != nil {
+		close(replies)
+		writeErr = <-written
+	}
+	// the records whose rows were written before a write error are done
+	if err := cp.Save(); err != nil {
+		log.Println("failed to save checkpoint", err)
+	}
+	if writeErr != nil {
+		log.Panicln("failed to write output", writeErr)
+	}
+
+	if errors.Is(readErr, context.Canceled) {
+		log.Println("interrupted, stopped reading input")
+	} else if readErr != nil {
+		log.Panicln("failed to read input", readErr)
+	}
 }
@@ -1,10 +1,22 @@
 module dnscensor
 
-go 1.21
+go 1.23
 
 require (
+	github.com/BurntSushi/toml v1.6.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 	www.bamsoftware.com/git/dnstt.git v1.20210812.0 // indirect
 )
 
 require common v1.0.0
+
+require (
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/ulikunitz/xz v0.5.15 // indirect
+)
+
 replace common => ../common
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/parseipportargs"
+
+	"www.bamsoftware.com/git/dnstt.git/dns"
+)
+
+// queryKey identifies a query by what its replies repeat: they come from the ip:port
+// the query was sent to, with its ID and its question.
+type queryKey struct {
+	resolver netip.AddrPort
+	id       uint16
+	name     string // lower case, as replies may change the case
+	qtype    uint16
+	class    uint16
+}
+
+// pendingQuery is a query waiting for its replies.
+type pendingQuery struct {
+	domain string
+	proto  string
+	at     time.Time
+	// where the domain came from, for -provenance
+	source string
+	line   int64
+	offset int64
+}
+
+// reply is a row of the output.
+type reply struct {
+	Time     time.Time `json:"time"`
+	Domain   string    `json:"domain"`
+	Type     string    `json:"type"`
+	Resolver string    `json:"resolver"`
+	Proto    string    `json:"proto"`
+	Rcode    string    `json:"rcode"`
+	Answers  []string  `json:"answers"`
+	// round-trip time in milliseconds
+	RTT     float64 `json:"rtt_ms"`
+	ASN     string  `json:"asn,omitempty"`
+	Country string  `json:"country,omitempty"`
+	Source  string  `json:"source,omitempty"`
+	Line    int64   `json:"line,omitempty"`
+	Offset  int64   `json:"offset,omitempty"`
+
+	// if not nil, the row is not written, but done is called as the rows before it are written
+	done func()
+}
+
+// receiver matches the replies read on the sockets of a worker to its queries.
+// A query stays matchable during the drain timeout, so that every reply is recorded,
+// eg. a forged reply injected by a censor and then the reply of the resolver.
+// A nil receiver ignores queries.
+type receiver struct {
+	drain   time.Duration
+	replies chan<- reply
+
+	mu      sync.Mutex
+	pending map[queryKey]pendingQuery
+	// records whose queries are all sent, oldest first
+	records []sentRecord
+	swept   time.Time
+	last    time.Time
+
+	// sending is read-locked while a row is sent to replies, and locked while done markers are,
+	// so that the rows of a record are always sent before its done marker
+	sending sync.RWMutex
+}
+
+// sentRecord is an input record whose queries are all sent.
+type sentRecord struct {
+	at   time.Time
+	done func()
+}
+
+func newReceiver(drain time.Duration, replies chan<- reply) *receiver {
+	if replies == nil {
+		return nil
+	}
+	return &receiver{drain: drain, replies: replies, pending: make(map[queryKey]pendingQuery), swept: time.Now()}
+}
+
+// add records a query, before it is sent.
+func (r *receiver) add(k queryKey, q pendingQuery) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	var done []func()
+	// forget the queries whose replies are not awaited anymore, once per drain timeout
+	if q.at.Sub(r.swept) > r.drain {
+		done = r.expire(q.at.Add(-r.drain))
+		r.swept = q.at
+	}
+	r.pending[k] = q
+	r.last = q.at
+	r.mu.Unlock()
+	r.markDone(done)
+}
+
+// sent records that all the queries of an input record are sent. done is called once the rows
+// of their replies are written, after the drain timeout, or right away by a nil receiver.
+func (r *receiver) sent(done func()) {
+	if r == nil {
+		done()
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, sentRecord{time.Now(), done})
+}
+
+// expire forgets the queries sent before t, and returns the done funcs of the records sent before t.
+// r.mu must be held.
+func (r *receiver) expire(t time.Time) []func() {
+	for k, p := range r.pending {
+		if p.at.Before(t) {
+			delete(r.pending, k)
+		}
+	}
+	var done []func()
+	n := 0
+	for ; n < len(r.records) && r.records[n].at.Before(t); n++ {
+		done = append(done, r.records[n].done)
+	}
+	r.records = r.records[n:]
+	return done
+}
+
+// markDone sends the done markers of expired records, after the rows of the replies matched before
+// they expired. r.mu must not be held, as the rows are sent without it.
+func (r *receiver) markDone(done []func()) {
+	if len(done) == 0 {
+		return
+	}
+	r.sending.Lock()
+	defer r.sending.Unlock()
+	for _, d := range done {
+		r.replies <- reply{done: d}
+	}
+}
+
+// finish waits until the drain timeout has passed since the last query, even if every query has a reply,
+// as more may come, and forgets all queries, so that the done markers of all records are sent.
+// The replies received afterwards are not recorded.
+func (r *receiver) finish() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	deadline := r.last.Add(r.drain)
+	r.mu.Unlock()
+	time.Sleep(time.Until(deadline))
+	r.mu.Lock()
+	done := r.expire(time.Now().Add(time.Nanosecond))
+	r.mu.Unlock()
+	r.markDone(done)
+}
+
+// match writes a row for a reply received from resolver, if it matches a query.
+func (r *receiver) match(resolver netip.AddrPort, buf []byte, at time.Time) {
+	// IPv4 replies on a dual-stack socket come from IPv4-mapped addresses
+	resolver = netip.AddrPortFrom(resolver.Addr().Unmap(), resolver.Port())
+	msg, err := dns.MessageFromWireFormat(buf)
+	if err != nil {
+		log.Println("malformed reply from", resolver, err)
+		return
+	}
+	if len(msg.Question) != 1 {
+		log.Println("reply from", resolver, "with", len(msg.Question), "questions")
+		return
+	}
+	question := msg.Question[0]
+	k := queryKey{resolver, msg.ID, strings.ToLower(question.Name.String()), question.Type, question.Class}
+	r.mu.Lock()
+	q, ok := r.pending[k]
+	if !ok || at.Sub(q.at) > r.drain {
+		r.mu.Unlock()
+		log.Println("unmatched reply from", resolver, "of type", parseipportargs.RRType(question.Type), "query of:", question.Name)
+		return
+	}
+	// the done marker of the record of the query, if it expires now, is sent after the row
+	r.sending.RLock()
+	defer r.sending.RUnlock()
+	r.mu.Unlock()
+	row := reply{
+		Time:     at,
+		Domain:   q.domain,
+		Type:     parseipportargs.RRType(question.Type).String(),
+		Resolver: resolver.String(),
+		Proto:    q.proto,
+		Rcode:    rcodeName(msg.Rcode()),
+		RTT:      float64(at.Sub(q.at).Microseconds()) / 1000,
+		Source:   q.source,
+		Line:     q.line,
+		Offset:   q.offset,
+		Answers:  []string{},
+	}
+	for _, rr := range msg.Answer {
+		row.Answers = append(row.Answers, formatRR(rr, buf))
+	}
+	if parseipportargs.DefaultIPDB.Enabled() {
+		o, err := parseipportargs.DefaultIPDB.Lookup(resolver.Addr())
+		if err != nil {
+			log.Println("failed to look up the origin of", resolver, err)
+		}
+		if o.ASN != 0 {
+			row.ASN = strconv.FormatUint(uint64(o.ASN), 10)
+		}
+		row.Country = o.Country
+	}
+	r.replies <- row
+}
+
+// readUDP matches the replies read on conn until it is closed.
+func (r *receiver) readUDP(conn *net.UDPConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("failed to read replies", err)
+			}
+			return
+		}
+		r.match(from, buf[:n], time.Now())
+	}
+}
+
+// readTCP matches the replies read on conn, each prefixed by its length, until it is closed.
+func (r *receiver) readTCP(conn net.Conn, resolver netip.AddrPort) {
+	br := bufio.NewReader(conn)
+	for {
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				log.Println("failed to read replies from", resolver, err)
+			}
+			return
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			log.Println("failed to read replies from", resolver, err)
+			return
+		}
+		r.match(resolver, buf, time.Now())
+	}
+}
+
+var rcodeNames = map[uint16]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-6
+func rcodeName(rcode uint16) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// formatRR formats the type and the data of an answer of msg: the address of A and AAAA records,
+// the domain names of NS, CNAME, PTR, DNAME and MX records, decompressed from msg,
+// and the generic format of RFC 3597 otherwise, eg. TXT \# 4 03616263.
+func formatRR(rr dns.RR, msg []byte) string {
+	t := parseipportargs.RRType(rr.Type)
+	if (rr.Type == 1 && len(rr.Data) == 4) || (rr.Type == 28 && len(rr.Data) == 16) {
+		ip, _ := netip.AddrFromSlice(rr.Data)
+		return fmt.Sprintf("%v %v", t, ip)
+	}
+	switch rr.Type {
+	case 2, 5, 12, 39:
+		if name, end, err := readName(msg, rr.Data, 0); err == nil && end == len(rr.Data) {
+			return fmt.Sprintf("%v %v", t, name)
+		}
+	case 15:
+		if len(rr.Data) > 2 {
+			if name, end, err := readName(msg, rr.Data, 2); err == nil && end == len(rr.Data) {
+				return fmt.Sprintf("%v %v %v", t, binary.BigEndian.Uint16(rr.Data), name)
+			}
+		}
+	}
+	return fmt.Sprintf("%v \\# %v %x", t, len(rr.Data), rr.Data)
+}
+
+// readName reads the domain name at off in data, the rdata of a record of msg, following
+// the compression pointers into msg, and returns the offset after the name in data.
+func readName(msg, data []byte, off int) (dns.Name, int, error) {
+	var labels [][]byte
+	buf, end := data, -1
+	for pointers := 0; ; {
+		if off >= len(buf) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		length := int(buf[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			name, err := dns.NewName(labels)
+			return name, end, err
+		case length&0xc0 == 0xc0:
+			if off+2 > len(buf) {
+				return nil, 0, io.ErrUnexpectedEOF
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			// a name has at most 127 labels, more pointers are a loop
+			if pointers++; pointers > 127 {
+				return nil, 0, errors.New("too many compression pointers")
+			}
+			buf, off = msg, int(binary.BigEndian.Uint16(buf[off:])&0x3fff)
+		case length&0xc0 != 0:
+			return nil, 0, fmt.Errorf("reserved label type %#x", length&0xc0)
+		default:
+			if off+1+length > len(buf) {
+				return nil, 0, io.ErrUnexpectedEOF
+			}
+			labels = append(labels, buf[off+1:off+1+length])
+			off += 1 + length
+		}
+	}
+}
+
+// writeReplies writes the replies as csv or ndjson rows to w, until replies is closed.
+// It calls the done func of the markers among them, once the rows before are written.
+// After a write error, it keeps reading replies, so that the receivers do not block, but drops them
+// without calling the done funcs, and returns the error once replies is closed.
+func writeReplies(w io.Writer, format string, provenance bool, replies <-chan reply) error {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	var err error
+	n, dropped := 0, 0
+	for r := range replies {
+		if err != nil {
+			if r.done == nil {
+				dropped++
+			}
+			continue
+		}
+		if r.done != nil {
+			r.done()
+			continue
+		}
+		if err = writeReply(bw, cw, enc, format, provenance, r); err != nil {
+			log.Println("failed to write replies, dropping the next ones:", err)
+			dropped++
+			continue
+		}
+		n++
+	}
+	if err != nil {
+		log.Println("received", n+dropped, "replies, dropped", dropped)
+		return err
+	}
+	log.Println("received", n, "replies")
+	return bw.Flush()
+}
+
+// writeReply writes a reply as a csv or ndjson row, and flushes it.
+func writeReply(bw *bufio.Writer, cw *csv.Writer, enc *json.Encoder, format string, provenance bool, r reply) error {
+	if format == "ndjson" {
+		if !provenance {
+			r.Source, r.Line, r.Offset = "", 0, 0
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	row := []string{strconv.FormatInt(r.Time.UnixMilli(), 10), r.Domain, r.Type, r.Resolver, r.Proto, r.Rcode, strings.Join(r.Answers, ";"), strconv.FormatFloat(r.RTT, 'f', -1, 64)}
+	if parseipportargs.DefaultIPDB.Enabled() {
+		row = append(row, r.ASN, r.Country)
+	}
+	if provenance {
+		row = append(row, r.Source, strconv.FormatInt(r.Line, 10), strconv.FormatInt(r.Offset, 10))
+	}
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -10,25 +10,10 @@ date=$(date +%F_%H-%M-%S)
 # cd into the dir of this script
 cd "$(dirname "$0")" || exit
 
-sudo pkill tcpdump || true
-
-mkdir -p pcap && sudo chmod 777 pcap
 mkdir -p data && sudo chmod 777 data
 
-
-# TODO: need to specify hosts with 'or'
-# capture DNS replies
-sudo tcpdump -nn src port 53 and udp and not icmp -Uw "pcap/${node_name}_${date}.pcap" -Z root &
-
-# wait for tcpdump to start
-sleep 5
-
-## find -L uniq -name "*.txt.uniq" -exec ./dnscensor -dip "$blackhole_ip" {} +
-## The command above does not work in crontab (but works if executed manually). We thus use the following workaround:
-#find -L uniq -name "*.txt.uniq" -exec cat {} + | ./dnscensor
-cat - | ./dnscensor "$@"
-
-# wait until all response come
-sleep 5
-
-sudo pkill tcpdump
+# with no FILE, dnscensor reads stdin. Pass directories directly to read the files under them, eg.
+# ./run.sh -include "*.txt.uniq" uniq
+# the resolvers to probe must be given with -dip or a -config profile, eg. ./run.sh -dip 8.8.8.8 uniq
+# dnscensor records the replies itself, and waits up to -drain for the last ones before exiting
+./dnscensor -receive -drain 5s -out "data/${node_name}_${date}.csv" "$@"
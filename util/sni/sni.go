@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net"
+	"net/netip"
 	"os"
+	"os/signal"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -17,6 +24,8 @@ import (
 	"syscall"
 	"time"
 
+	"common/config"
+	"common/domainlist"
 	"common/parseipportargs"
 	"common/readfiles"
 )
@@ -26,7 +35,7 @@ func usage() {
     %[1]s [OPTION]... [FILE]...
 
 Description:
-    Test if SNI values in FILE(s) are censored. With no FILE, or when FILE is -, read standard input. By default, print results to stdout and log to stderr.
+    Test if SNI values in FILE(s) are censored. With no FILE, or when FILE is -, read standard input. Compressed FILE(s) (gzip, zstd, xz, bzip2) are decompressed transparently. A directory FILE is read recursively, and the members of tar and zip archives are read as separate inputs. By default, print results to stdout and log to stderr.
 
 Examples:
     Make a TLS connection, whose SNI is www.youtube.com, to the port 1000 of 1.1.1.1
@@ -41,8 +50,15 @@ Options:
 	flag.PrintDefaults()
 }
 
-func worker(id int, jobs chan string, addrs chan string, results chan<- []string, dialer *net.Dialer) {
-	for j := range jobs {
+// output is a row of the output csv, along with the input record it is about.
+type output struct {
+	rec readfiles.Record
+	row []string
+}
+
+func worker(id int, jobs chan readfiles.Record, addrs chan string, results chan<- output, dialer *net.Dialer) {
+	for rec := range jobs {
+		j := rec.Text
 		// skip empty domain names
 		if len(j) == 0 {
 			continue
@@ -146,31 +162,78 @@ func worker(id int, jobs chan string, addrs chan string, results chan<- []string
 		duration := endTime.Sub(startTime)
 		durationMillis := duration.Milliseconds()
 
-		results <- []string{strconv.FormatInt(startTime.UnixMilli(), 10), j, stage, code, addr, fmt.Sprintf("%v", durationMillis)}
+		result := []string{strconv.FormatInt(startTime.UnixMilli(), 10), j, stage, code, addr, fmt.Sprintf("%v", durationMillis)}
+		if parseipportargs.DefaultIPDB.Enabled() {
+			result = append(result, origin(addr)...)
+		}
+		if *provenance {
+			result = append(result, rec.Source, strconv.FormatInt(rec.Line, 10), strconv.FormatInt(rec.Offset, 10))
+		}
+		results <- output{rec, result}
 		log.Println("worker", id, "finished sending", j, "to", addr)
 	}
 }
 
+// origin returns the AS number and the country code of the IP of addr, as columns of the output.
+func origin(addr string) []string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return []string{"", ""}
+	}
+	o, err := parseipportargs.DefaultIPDB.Lookup(ap.Addr())
+	if err != nil {
+		log.Println("failed to look up the origin of", addr, err)
+	}
+	asn := ""
+	if o.ASN != 0 {
+		asn = strconv.FormatUint(uint64(o.ASN), 10)
+	}
+	return []string{asn, o.Country}
+}
+
+// maxPoolSize is the maximum number of ip:port pairs waiting in the pool.
+const maxPoolSize = 1 << 16
+
 // global variables
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file.")
 var timeout = flag.Duration("timeout", 3*time.Second, "timeout value of TLS connections.")
 var residual = flag.Duration("residual", 180*time.Second, "redisual censorship duration of the GFW.")
+var provenance = flag.Bool("provenance", false, "append the input file, line number and byte offset of each domain to the output.")
 
 func main() {
 	flag.Usage = usage
 	var maxNumWorkers int
-	argIP := flag.String("dip", "127.0.0.1", "comma-separated list of destination IP addresses to which the program sends TLS ClientHellos. eg. 1.1.1.1,2.2.2.2")
-	argPort := flag.String("p", "10000-65000", "comma-separated list of ports to which the program sends TLS ClientHellos. eg. 3000,4000-4002")
+	argIP := flag.String("dip", "", "targets to which the program sends TLS ClientHellos, separated by spaces or ;. A target is a comma-separated list of destination IP addresses, optionally followed by :ports and /tcp, eg. 1.1.1.1,2.2.2.0/24,3.3.3.3-3.3.3.9,@ips.txt or [2001:db8::1]:443,8443, where asn:N and cc:XX select the IPs of an AS or a country in -ipdb, and @ips.txt reads one IP, CIDR or range per line of the files matching ips.txt, or stdin for @-. Required. Reserved networks, such as 127.0.0.0/8, require -allow-reserved.")
+	argPort := flag.String("p", "10000-65000", "comma-separated list of ports to which the program sends TLS ClientHellos, for the targets without ports, eg. 3000,4000-4002. Ports can be excluded with !, eg. 10000-65000,!22,!30000-30100, and named by groups: all, well-known, registered, ephemeral, or topN for the N most frequently open tcp ports.")
 	flag.IntVar(&maxNumWorkers, "worker", 10000*2, fmt.Sprintf("number of workers in parallel."))
 	outputFile := flag.String("out", "", "output csv file.  (default stdout)")
 	logFile := flag.String("log", "", "log to file.  (default stderr)")
 	flush := flag.Bool("flush", true, "flush after every output.")
+	checkpointFile := flag.String("checkpoint", "", "save the progress to file periodically, to be able to -resume after a crash. Cannot be used with -shuffle or -sample N.")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "how often to save -checkpoint.")
+	resume := flag.Bool("resume", false, "skip the domains done according to -checkpoint, and append to -out, -log and -rejects instead of overwriting them.")
+	var format domainlist.Format
+	flag.Var(&format, "format", "format of input files: auto (default), plain, csv (rank,domain) or zone (owner names of a zone file).")
+	rejectsFile := flag.String("rejects", "", "write input lines which are not valid domains to csv file, with the reason. (default drop them)")
+	targetSeed := flag.Int64("target-seed", 0, "random seed of the order of the ip:port pairs, to reproduce it. 0 picks a random seed, which is logged.")
+	var targetShard readfiles.Shard
+	flag.Var(&targetShard, "target-shard", "send only to shard `k/n` of the ip:port pairs, where 0 <= k < n, to split them among n senders using the same -target-seed.")
+	metaFile := flag.String("meta", "", "write the metadata of the run, such as the targets and the IPs their hostnames resolved to, to json file.")
+	readfiles.RegisterFlags(flag.CommandLine)
+	parseipportargs.RegisterFlags(flag.CommandLine)
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := config.Apply(flag.CommandLine, "snicensor"); err != nil {
+		log.Panicln("failed to apply the configuration", err)
+	}
+	if *argIP == "" {
+		log.Panicln("-dip is required: give the targets to send the ClientHellos to, eg. -dip 1.1.1.1")
+	}
 
 	// log, intentionally make it blocking to make sure it got
 	// initiliazed before other parts using it
 	if *logFile != "" {
-		f, err := os.Create(*logFile)
+		f, err := create(*logFile, *resume)
 		if err != nil {
 			log.Panicln("failed to open log file", err)
 		}
@@ -184,7 +247,7 @@ func main() {
 	if *outputFile == "" {
 		f = os.Stdout
 	} else {
-		f, err = os.Create(*outputFile)
+		f, err = create(*outputFile, *resume)
 		if err != nil {
 			log.Panicln("failed to open output file", err)
 		}
@@ -201,39 +264,130 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	ips, err := parseipportargs.ParseIPArgs(*argIP)
+	if *resume && *checkpointFile == "" {
+		log.Panicln("-resume requires -checkpoint")
+	}
+	var cp *readfiles.Checkpoint
+	if *checkpointFile != "" {
+		if err := readfiles.CheckOrder(readfiles.DefaultOptions); err != nil {
+			log.Panicln(err)
+		}
+		cp, err = readfiles.NewCheckpoint(*checkpointFile, *checkpointInterval, *resume)
+		if err != nil {
+			log.Panicln("failed to load checkpoint", err)
+		}
+	}
+
+	var rejects *domainlist.Rejects
+	if *rejectsFile != "" {
+		rejects, err = domainlist.CreateRejects(*rejectsFile, *resume)
+		if err != nil {
+			log.Panicln("failed to open rejects file", err)
+		}
+		defer rejects.Close()
+	}
+
+	ports, err := parseipportargs.ParsePortSet(*argPort, parseipportargs.TCP)
 	if err != nil {
 		log.Panic(err)
 	}
-
-	ports, err := parseipportargs.ParsePortArgs(*argPort)
+	if err := parseipportargs.DefaultIPDB.Load(); err != nil {
+		log.Panic(err)
+	}
+	targets, err := parseipportargs.ParseTargets(*argIP, ports, parseipportargs.TCP)
 	if err != nil {
 		log.Panic(err)
 	}
-	maxNumAddrs := len(ports) * len(ips)
-	addrs := make(chan string, maxNumAddrs)
+	if targetShard.N == 0 {
+		targetShard.N = 1
+	}
+	seed := *targetSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+		log.Println("permuting targets with random seed", seed)
+	}
+	// every target is permuted with its own seed, derived from seed
+	rng := rand.New(rand.NewSource(seed))
+	perms := make([]*parseipportargs.Permutation, len(targets))
+	var numAddrs uint64
+	for i, t := range targets {
+		if t.Proto != parseipportargs.TCP {
+			log.Panicln("TLS needs tcp targets:", t)
+		}
+		perms[i], err = parseipportargs.NewPermutation(t.IPs, t.Ports, rng.Int63()|1, targetShard.K, targetShard.N)
+		if err != nil {
+			log.Panic(err)
+		}
+		// the pairs walked in order are too many to count
+		if n := perms[i].Len(); numAddrs+n < numAddrs {
+			numAddrs = math.MaxUint64
+		} else {
+			numAddrs += n
+		}
+	}
+	if *metaFile != "" {
+		m := meta{Started: time.Now(), Args: os.Args, Resolved: parseipportargs.DefaultResolver.Resolutions(), TargetSeed: seed}
+		for _, t := range targets {
+			m.Targets = append(m.Targets, t.String())
+		}
+		if err := writeMeta(*metaFile, m); err != nil {
+			log.Panicln("failed to write metadata", err)
+		}
+	}
+	// The pool is filled lazily, as the workers take addresses from it, so that
+	// it holds at most maxPoolSize addresses whatever the size of the prefixes.
+	poolSize := uint64(maxPoolSize)
+	if numAddrs < poolSize {
+		poolSize = numAddrs
+	}
+	addrs := make(chan string, poolSize)
 
 	// The channel capacity does not have to be equal to the
 	// number of workers. It can be much smaller.
-	jobs := make(chan string, 100)
-	results := make(chan []string, 100)
-
-	lines := readfiles.ReadFiles(flag.Args())
-
+	jobs := make(chan readfiles.Record, 100)
+	results := make(chan output, 100)
+
+	// stop reading new domains on SIGINT or SIGTERM, and let the workers
+	// finish the jobs at hand so that their results are still written.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// parse the domains before they are sharded, deduplicated, sampled or shuffled,
+	// every one of them, even skipped ones, to keep the state of zone files
+	opts := readfiles.DefaultOptions
+	opts.Parse = domainlist.NewParser(format).Filter(rejects, cp.Skip)
+	records, errs := readfiles.ReadRecords(ctx, flag.Args(), opts)
+
+	var readErr error
 	go func() {
-		for line := range lines {
-			jobs <- line
+		skipped := 0
+		for rec := range records {
+			if cp.Skip(rec) {
+				skipped++
+				continue
+			}
+			cp.Start(rec)
+			jobs <- rec
+		}
+		if skipped > 0 {
+			log.Println("skipped", skipped, "domains done before resuming")
 		}
+		readErr = <-errs
 		close(jobs)
 	}()
 
 	go func() {
-		for _, port := range ports {
-			// Create a pool of ip-port pairs to which we send ClientHellos.
-			// It is important to loop port then ip, to send to different servers evenly.
-			for _, ip := range ips {
-				addrs <- net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		// Create a pool of ip-port pairs to which we send ClientHellos.
+		// They are permuted pseudorandomly, to send to different servers evenly
+		// and in an order that is hard to predict, taking turns between the targets.
+		for len(perms) > 0 {
+			active := perms[:0]
+			for _, perm := range perms {
+				if addr, ok := perm.Next(); ok {
+					addrs <- addr.String()
+					active = append(active, perm)
+				}
 			}
+			perms = active
 		}
 		// do not close(addrs) as we still need to pop and push
 	}()
@@ -257,14 +411,58 @@ func main() {
 	for r := range results {
 		// comment out to measure and decide a proper capacity of the chan
 		// log.Println("Number of Element in results chan:", len(results))
-		if err := w.Write(r); err != nil {
+		if err := w.Write(r.row); err != nil {
 			log.Panicln("error writing results to file", err)
 		}
 		if *flush {
 			w.Flush()
 		}
+		cp.Done(r.rec)
+		if cp.Due() {
+			// results must hit the file before the checkpoint says they are done
+			w.Flush()
+			if err := cp.Save(); err != nil {
+				log.Println("failed to save checkpoint", err)
+			}
+		}
 	}
 	w.Flush()
+	if err := cp.Save(); err != nil {
+		log.Println("failed to save checkpoint", err)
+	}
+
+	if errors.Is(readErr, context.Canceled) {
+		log.Println("interrupted, stopped reading input")
+	} else if readErr != nil {
+		log.Panicln("failed to read input", readErr)
+	}
+}
+
+// meta is the metadata of a run, to reproduce its results.
+type meta struct {
+	Started time.Time `json:"started"`
+	Args    []string  `json:"args"`
+	Targets []string  `json:"targets"`
+	// IPs of the hostnames among the targets, resolved once at startup
+	Resolved   map[string][]netip.Addr `json:"resolved,omitempty"`
+	TargetSeed int64                   `json:"target_seed"`
+}
+
+// writeMeta writes m to the named file as JSON.
+func writeMeta(name string, m meta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// create creates or truncates the named file, or opens it for appending when resuming a run.
+func create(name string, resume bool) (*os.File, error) {
+	if resume {
+		return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	return os.Create(name)
 }
 
 func hello(conn net.Conn, sni string) error {